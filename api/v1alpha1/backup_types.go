@@ -0,0 +1,125 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionCompleted is true once a run has finished, regardless of its outcome.
+	ConditionCompleted = "Completed"
+
+	// ReasonRunning is used while a run is in progress.
+	ReasonRunning = "Running"
+	// ReasonSucceeded is used if a run finished without errors.
+	ReasonSucceeded = "Succeeded"
+	// ReasonFailed is used if a run finished with errors.
+	ReasonFailed = "Failed"
+	// ReasonInterrupted is used if the operator stopped during a run.
+	ReasonInterrupted = "Interrupted"
+)
+
+// BackupSpec defines which PVCs should be backed up and how.
+type BackupSpec struct {
+	// NamespaceSelector selects the namespaces whose PVCs get backed up.
+	// All namespaces are selected if it's empty.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// PVCSelector selects the PVCs that get backed up.
+	// All PVCs are selected if it's empty.
+	// +optional
+	PVCSelector *metav1.LabelSelector `json:"pvcSelector,omitempty"`
+
+	// Concurrency defines how many backup jobs run at the same time.
+	// Defaults to the concurrency of the operator.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// RepositoryRef references the Repository the backups are stored in.
+	// Defaults to the repository configured on the operator.
+	// +optional
+	RepositoryRef *RepositoryReference `json:"repositoryRef,omitempty"`
+}
+
+// RepositoryReference references a Repository by name.
+type RepositoryReference struct {
+	// Name of the Repository.
+	Name string `json:"name"`
+}
+
+// PVCResult contains the outcome of the backup of a single PVC.
+type PVCResult struct {
+	Namespace string `json:"namespace"`
+	PVC       string `json:"pvc"`
+	// Status is either succeeded, failed or skipped.
+	Status     string `json:"status"`
+	SnapshotID string `json:"snapshotID,omitempty"`
+	// Size of the snapshot in bytes.
+	Size        int64 `json:"size,omitempty"`
+	Files       int   `json:"files,omitempty"`
+	FailedFiles int   `json:"failedFiles,omitempty"`
+}
+
+// BackupStatus defines the observed state of Backup
+type BackupStatus struct {
+	// Conditions contain the state of the backup run.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// StartTime is the time the backup run started.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// FinishTime is the time the backup run finished.
+	// +optional
+	FinishTime *metav1.Time `json:"finishTime,omitempty"`
+
+	// Results contain the outcome of each backed up PVC.
+	// +optional
+	Results []PVCResult `json:"results,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Completed",type="string",JSONPath=".status.conditions[?(@.type==\"Completed\")].reason"
+//+kubebuilder:printcolumn:name="Started",type="date",JSONPath=".status.startTime"
+//+kubebuilder:printcolumn:name="Finished",type="date",JSONPath=".status.finishTime"
+
+// Backup is a single backup run of the selected PVCs.
+type Backup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BackupSpec   `json:"spec,omitempty"`
+	Status BackupStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// BackupList contains a list of Backup
+type BackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Backup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Backup{}, &BackupList{})
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// BackendS3 stores the repository in an S3 compatible bucket.
+	BackendS3 = "s3"
+	// BackendFilesystem stores the repository in a directory on a volume.
+	BackendFilesystem = "filesystem"
+	// BackendSFTP stores the repository on an SFTP server.
+	BackendSFTP = "sftp"
+	// BackendAzure stores the repository in an Azure Blob Storage container.
+	BackendAzure = "azure"
+	// BackendGCS stores the repository in a Google Cloud Storage bucket.
+	BackendGCS = "gcs"
+	// BackendB2 stores the repository in a Backblaze B2 bucket.
+	BackendB2 = "b2"
+)
+
+// RepositorySpec defines where the kopia repository is stored and how to access it.
+type RepositorySpec struct {
+	// Type of the storage backend.
+	// +kubebuilder:validation:Enum=s3;filesystem;sftp;azure;gcs;b2
+	// +kubebuilder:default=s3
+	Type string `json:"type"`
+
+	// S3 configures the S3 backend, required if the type is s3.
+	// +optional
+	S3 *S3Backend `json:"s3,omitempty"`
+
+	// Filesystem configures the filesystem backend, required if the type is filesystem.
+	// +optional
+	Filesystem *FilesystemBackend `json:"filesystem,omitempty"`
+
+	// SFTP configures the SFTP backend, required if the type is sftp.
+	// +optional
+	SFTP *SFTPBackend `json:"sftp,omitempty"`
+
+	// Azure configures the Azure Blob Storage backend, required if the type is azure.
+	// +optional
+	Azure *AzureBackend `json:"azure,omitempty"`
+
+	// GCS configures the Google Cloud Storage backend, required if the type is gcs.
+	// +optional
+	GCS *GCSBackend `json:"gcs,omitempty"`
+
+	// B2 configures the Backblaze B2 backend, required if the type is b2.
+	// +optional
+	B2 *B2Backend `json:"b2,omitempty"`
+
+	// EncryptionPasswordSecretRef references the password the repository is encrypted with.
+	EncryptionPasswordSecretRef corev1.SecretKeySelector `json:"encryptionPasswordSecretRef"`
+}
+
+// S3Backend defines an S3 compatible bucket.
+type S3Backend struct {
+	// Bucket is the name of the bucket.
+	Bucket string `json:"bucket"`
+
+	// Prefix is prepended to all objects of the repository in the bucket.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Endpoint of the S3 API, e.g. s3.amazonaws.com.
+	Endpoint string `json:"endpoint"`
+
+	// TLS configures the connection to the endpoint.
+	// +optional
+	TLS S3TLS `json:"tls,omitempty"`
+
+	// AccessKeyIDSecretRef references the access key ID.
+	AccessKeyIDSecretRef corev1.SecretKeySelector `json:"accessKeyIDSecretRef"`
+
+	// SecretAccessKeySecretRef references the secret access key.
+	SecretAccessKeySecretRef corev1.SecretKeySelector `json:"secretAccessKeySecretRef"`
+}
+
+// S3TLS configures the TLS connection to the S3 endpoint.
+type S3TLS struct {
+	// Disabled connects to the endpoint with plain HTTP.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// InsecureSkipVerify skips the verification of the endpoint's certificate.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// FilesystemBackend stores the repository in a directory on a volume.
+// Either PersistentVolumeClaim or NFS has to be set.
+type FilesystemBackend struct {
+	// Path is the directory of the repository. The volume gets mounted there in the jobs.
+	Path string `json:"path"`
+
+	// PersistentVolumeClaim contains the repository.
+	// As the jobs run in the namespace of the backed up PVC, the claim has to exist in each of these namespaces.
+	// +optional
+	PersistentVolumeClaim *corev1.PersistentVolumeClaimVolumeSource `json:"persistentVolumeClaim,omitempty"`
+
+	// NFS is a share that contains the repository.
+	// +optional
+	NFS *corev1.NFSVolumeSource `json:"nfs,omitempty"`
+}
+
+// SFTPBackend stores the repository on an SFTP server.
+// Either KeySecretRef or PasswordSecretRef has to be set.
+type SFTPBackend struct {
+	// Host is the hostname of the server.
+	Host string `json:"host"`
+
+	// Port of the server.
+	// +kubebuilder:default=22
+	// +optional
+	Port int `json:"port,omitempty"`
+
+	// Path is the directory of the repository on the server.
+	Path string `json:"path"`
+
+	// Username to log in with.
+	Username string `json:"username"`
+
+	// KnownHosts contains the known_hosts entries of the server.
+	KnownHosts string `json:"knownHosts"`
+
+	// KeySecretRef references the private key to log in with.
+	// +optional
+	KeySecretRef *corev1.SecretKeySelector `json:"keySecretRef,omitempty"`
+
+	// PasswordSecretRef references the password to log in with.
+	// It's only used if there's no key.
+	// +optional
+	PasswordSecretRef *corev1.SecretKeySelector `json:"passwordSecretRef,omitempty"`
+}
+
+// AzureBackend stores the repository in an Azure Blob Storage container.
+// Either StorageKeySecretRef or SASTokenSecretRef has to be set.
+type AzureBackend struct {
+	// Container is the name of the container.
+	Container string `json:"container"`
+
+	// Prefix is prepended to all blobs of the repository in the container.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// StorageAccount is the name of the storage account.
+	StorageAccount string `json:"storageAccount"`
+
+	// StorageDomain overrides the domain of the storage service, e.g. for sovereign clouds.
+	// +optional
+	StorageDomain string `json:"storageDomain,omitempty"`
+
+	// StorageKeySecretRef references the access key of the storage account.
+	// +optional
+	StorageKeySecretRef *corev1.SecretKeySelector `json:"storageKeySecretRef,omitempty"`
+
+	// SASTokenSecretRef references a shared access signature token.
+	// It takes precedence over the storage key.
+	// +optional
+	SASTokenSecretRef *corev1.SecretKeySelector `json:"sasTokenSecretRef,omitempty"`
+}
+
+// GCSBackend stores the repository in a Google Cloud Storage bucket.
+type GCSBackend struct {
+	// Bucket is the name of the bucket.
+	Bucket string `json:"bucket"`
+
+	// Prefix is prepended to all objects of the repository in the bucket.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// CredentialsSecretRef references the JSON key of a service account.
+	CredentialsSecretRef corev1.SecretKeySelector `json:"credentialsSecretRef"`
+}
+
+// B2Backend stores the repository in a Backblaze B2 bucket.
+type B2Backend struct {
+	// Bucket is the name of the bucket.
+	Bucket string `json:"bucket"`
+
+	// Prefix is prepended to all objects of the repository in the bucket.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// KeyIDSecretRef references the ID of the application key.
+	KeyIDSecretRef corev1.SecretKeySelector `json:"keyIDSecretRef"`
+
+	// KeySecretRef references the application key.
+	KeySecretRef corev1.SecretKeySelector `json:"keySecretRef"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Type",type="string",JSONPath=".spec.type"
+//+kubebuilder:printcolumn:name="Bucket",type="string",JSONPath=".spec.s3.bucket"
+
+// Repository describes a kopia repository the backups are stored in.
+type Repository struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RepositorySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RepositoryList contains a list of Repository
+type RepositoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Repository `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Repository{}, &RepositoryList{})
+}
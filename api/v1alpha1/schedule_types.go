@@ -0,0 +1,119 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionScheduled is true if all cron expressions of the schedule are valid.
+	// Otherwise nothing is scheduled until the spec changes.
+	ConditionScheduled = "Scheduled"
+
+	// ReasonValid is used if all cron expressions are valid.
+	ReasonValid = "Valid"
+	// ReasonInvalidCron is used if a cron expression can't be parsed.
+	ReasonInvalidCron = "InvalidCron"
+)
+
+// ScheduleSpec defines when backups, maintenance and checks run.
+type ScheduleSpec struct {
+	// Backup is a cron expression that defines when a Backup gets created.
+	// +optional
+	Backup string `json:"backup,omitempty"`
+
+	// Maintenance is a cron expression that defines when the repository maintenance runs.
+	// +optional
+	Maintenance string `json:"maintenance,omitempty"`
+
+	// Check is a cron expression that defines when the snapshots in the repository get verified.
+	// +optional
+	Check string `json:"check,omitempty"`
+
+	// Jitter delays each run by a random duration up to the given value.
+	// This avoids that many runs start at the same time.
+	// +optional
+	Jitter *metav1.Duration `json:"jitter,omitempty"`
+
+	// BackupTemplate is the spec of the created Backups.
+	// +optional
+	BackupTemplate BackupSpec `json:"backupTemplate,omitempty"`
+
+	// BackupHistoryLimit is the number of finished Backups to keep.
+	// Defaults to 3.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	BackupHistoryLimit *int `json:"backupHistoryLimit,omitempty"`
+}
+
+// ScheduledRun contains when a scheduled run happened and when it happens next.
+type ScheduledRun struct {
+	// Cron is the cron expression NextTime was computed from.
+	// If it differs from the spec, NextTime gets recomputed.
+	// +optional
+	Cron string `json:"cron,omitempty"`
+
+	// LastTime is the time the run was last triggered.
+	// +optional
+	LastTime *metav1.Time `json:"lastTime,omitempty"`
+
+	// NextTime is the time the run gets triggered next, including the jitter.
+	// +optional
+	NextTime *metav1.Time `json:"nextTime,omitempty"`
+}
+
+// ScheduleStatus defines the observed state of Schedule
+type ScheduleStatus struct {
+	// Conditions contain whether the schedule is valid.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// +optional
+	Backup ScheduledRun `json:"backup,omitempty"`
+	// +optional
+	Maintenance ScheduledRun `json:"maintenance,omitempty"`
+	// +optional
+	Check ScheduledRun `json:"check,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Backup",type="string",JSONPath=".spec.backup"
+//+kubebuilder:printcolumn:name="Next Backup",type="date",JSONPath=".status.backup.nextTime"
+
+// Schedule creates Backups and runs the maintenance and checks periodically.
+type Schedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ScheduleSpec   `json:"spec,omitempty"`
+	Status ScheduleStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ScheduleList contains a list of Schedule
+type ScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Schedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Schedule{}, &ScheduleList{})
+}
@@ -14,7 +14,7 @@ import (
 )
 
 func setupLogging(c *cli.Context) error {
-	log := newZapLogger(appName, c.Bool("debug"), usesProductionLoggingConfig(c))
+	log := logger.NewRedactingLogger(newZapLogger(appName, c.Bool("debug"), usesProductionLoggingConfig(c)))
 	c.Context.Value(logger.ContextKey{}).(*atomic.Value).Store(log)
 	return nil
 }
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
 	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
 	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
 	"github.com/urfave/cli/v2"
@@ -15,6 +19,10 @@ func newKopiaCommand() *cli.Command {
 		Subcommands: []*cli.Command{
 			newKopiaBackupCommand(),
 			newKopiaMaintenanceCommand(),
+			newKopiaRestoreCommand(),
+			newKopiaSnapshotsCommand(),
+			newKopiaStatusCommand(),
+			newKopiaVerifyCommand(),
 		},
 		Flags: getKopiaParams(),
 	}
@@ -22,6 +30,118 @@ func newKopiaCommand() *cli.Command {
 
 func getKopiaParams() []cli.Flag {
 	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "storage-type",
+			Value:   kopia.StorageS3,
+			Usage:   "Storage backend of the repository (values: [s3, filesystem, sftp, azure, gcs, b2])",
+			EnvVars: envVars("STORAGE_TYPE"),
+		},
+		&cli.StringFlag{
+			Name:    "filesystem-path",
+			Usage:   "Directory of the repository for the filesystem storage",
+			EnvVars: envVars("FILESYSTEM_PATH"),
+		},
+		&cli.StringFlag{
+			Name:    "sftp-host",
+			Usage:   "Hostname of the SFTP server",
+			EnvVars: envVars("SFTP_HOST"),
+		},
+		&cli.IntFlag{
+			Name:    "sftp-port",
+			Value:   22,
+			Usage:   "Port of the SFTP server",
+			EnvVars: envVars("SFTP_PORT"),
+		},
+		&cli.StringFlag{
+			Name:    "sftp-path",
+			Usage:   "Directory of the repository on the SFTP server",
+			EnvVars: envVars("SFTP_PATH"),
+		},
+		&cli.StringFlag{
+			Name:    "sftp-username",
+			Usage:   "Username for the SFTP server",
+			EnvVars: envVars("SFTP_USERNAME"),
+		},
+		&cli.StringFlag{
+			Name:    "sftp-password",
+			Usage:   "Password for the SFTP server, only used without a key",
+			EnvVars: envVars("SFTP_PASSWORD"),
+		},
+		&cli.PathFlag{
+			Name:    "sftp-key-file",
+			Usage:   "Private key for the SFTP server",
+			EnvVars: envVars("SFTP_KEY_FILE"),
+		},
+		&cli.PathFlag{
+			Name:    "sftp-known-hosts-file",
+			Usage:   "known_hosts file with the key of the SFTP server",
+			EnvVars: envVars("SFTP_KNOWN_HOSTS_FILE"),
+		},
+		&cli.StringFlag{
+			Name:    "azure-container",
+			Usage:   "Name of the Azure Blob Storage container",
+			EnvVars: envVars("AZURE_CONTAINER"),
+		},
+		&cli.StringFlag{
+			Name:    "azure-prefix",
+			Usage:   "Prefix for all blobs of the repository in the Azure container",
+			EnvVars: envVars("AZURE_PREFIX"),
+		},
+		&cli.StringFlag{
+			Name:    "azure-storage-account",
+			Usage:   "Name of the Azure storage account",
+			EnvVars: envVars("AZURE_STORAGE_ACCOUNT"),
+		},
+		&cli.StringFlag{
+			Name:    "azure-storage-key",
+			Usage:   "Access key of the Azure storage account",
+			EnvVars: envVars("AZURE_STORAGE_KEY"),
+		},
+		&cli.StringFlag{
+			Name:    "azure-sas-token",
+			Usage:   "Azure shared access signature token, takes precedence over the storage key",
+			EnvVars: envVars("AZURE_SAS_TOKEN"),
+		},
+		&cli.StringFlag{
+			Name:    "azure-storage-domain",
+			Usage:   "Domain of the Azure storage service, e.g. for sovereign clouds",
+			EnvVars: envVars("AZURE_STORAGE_DOMAIN"),
+		},
+		&cli.StringFlag{
+			Name:    "gcs-bucket",
+			Usage:   "Name of the Google Cloud Storage bucket",
+			EnvVars: envVars("GCS_BUCKET"),
+		},
+		&cli.StringFlag{
+			Name:    "gcs-prefix",
+			Usage:   "Prefix for all objects of the repository in the GCS bucket",
+			EnvVars: envVars("GCS_PREFIX"),
+		},
+		&cli.PathFlag{
+			Name:    "gcs-credentials-file",
+			Usage:   "JSON key of the service account for Google Cloud Storage",
+			EnvVars: envVars("GCS_CREDENTIALS_FILE"),
+		},
+		&cli.StringFlag{
+			Name:    "b2-bucket",
+			Usage:   "Name of the Backblaze B2 bucket",
+			EnvVars: envVars("B2_BUCKET"),
+		},
+		&cli.StringFlag{
+			Name:    "b2-prefix",
+			Usage:   "Prefix for all objects of the repository in the B2 bucket",
+			EnvVars: envVars("B2_PREFIX"),
+		},
+		&cli.StringFlag{
+			Name:    "b2-key-id",
+			Usage:   "ID of the Backblaze B2 application key",
+			EnvVars: envVars("B2_KEY_ID"),
+		},
+		&cli.StringFlag{
+			Name:    "b2-key",
+			Usage:   "Backblaze B2 application key",
+			EnvVars: envVars("B2_KEY"),
+		},
 		&cli.StringFlag{
 			Name:    "access-key-id",
 			Usage:   "AWS access key ID",
@@ -47,6 +167,26 @@ func getKopiaParams() []cli.Flag {
 			Usage:   "Kopia S3 endpoint",
 			EnvVars: envVars("ENDPOINT"),
 		},
+		&cli.StringFlag{
+			Name:    "s3-prefix",
+			Usage:   "Prefix for all objects of the repository in the S3 bucket",
+			EnvVars: envVars("S3_PREFIX"),
+		},
+		&cli.BoolFlag{
+			Name:    "s3-disable-tls",
+			Usage:   "Connect to the S3 endpoint without TLS",
+			EnvVars: envVars("S3_DISABLE_TLS"),
+		},
+		&cli.BoolFlag{
+			Name:    "s3-disable-tls-verification",
+			Usage:   "Don't verify the certificate of the S3 endpoint",
+			EnvVars: envVars("S3_DISABLE_TLS_VERIFICATION"),
+		},
+		&cli.BoolFlag{
+			Name:    "create-if-missing",
+			Usage:   "Create the repository if the storage doesn't contain one yet, otherwise kopia-k8s fails",
+			EnvVars: envVars("CREATE_IF_MISSING"),
+		},
 		&cli.PathFlag{
 			Name:    "config",
 			Aliases: []string{"c"},
@@ -66,23 +206,95 @@ func getKopiaParams() []cli.Flag {
 			EnvVars: envVars("KOPIA_CACHE_PATH"),
 			Value:   "/cache",
 		},
+		&cli.IntFlag{
+			Name:    "keep-latest",
+			Usage:   "Number of most recent snapshots to keep, unset keeps the current policy and 0 keeps none",
+			EnvVars: envVars("KEEP_LATEST"),
+		},
+		&cli.IntFlag{
+			Name:    "keep-hourly",
+			Usage:   "Number of most recent hourly snapshots to keep, unset keeps the current policy and 0 keeps none",
+			EnvVars: envVars("KEEP_HOURLY"),
+		},
+		&cli.IntFlag{
+			Name:    "keep-daily",
+			Usage:   "Number of most recent daily snapshots to keep, unset keeps the current policy and 0 keeps none",
+			EnvVars: envVars("KEEP_DAILY"),
+		},
+		&cli.IntFlag{
+			Name:    "keep-weekly",
+			Usage:   "Number of most recent weekly snapshots to keep, unset keeps the current policy and 0 keeps none",
+			EnvVars: envVars("KEEP_WEEKLY"),
+		},
+		&cli.IntFlag{
+			Name:    "keep-monthly",
+			Usage:   "Number of most recent monthly snapshots to keep, unset keeps the current policy and 0 keeps none",
+			EnvVars: envVars("KEEP_MONTHLY"),
+		},
+		&cli.IntFlag{
+			Name:    "keep-annual",
+			Usage:   "Number of most recent annual snapshots to keep, unset keeps the current policy and 0 keeps none",
+			EnvVars: envVars("KEEP_ANNUAL"),
+		},
+	}
+}
+
+func retentionPolicyFromFlags(c *cli.Context) kopia.RetentionPolicy {
+	return kopia.RetentionPolicy{
+		KeepLatest:  retentionFlag(c, "keep-latest"),
+		KeepHourly:  retentionFlag(c, "keep-hourly"),
+		KeepDaily:   retentionFlag(c, "keep-daily"),
+		KeepWeekly:  retentionFlag(c, "keep-weekly"),
+		KeepMonthly: retentionFlag(c, "keep-monthly"),
+		KeepAnnual:  retentionFlag(c, "keep-annual"),
+	}
+}
+
+// retentionFlag returns nil if the flag isn't set, so that zero can turn off a time frame.
+func retentionFlag(c *cli.Context, name string) *int {
+	if !c.IsSet(name) {
+		return nil
 	}
+	value := c.Int(name)
+	return &value
 }
 
-func newKopiaInstance(c *cli.Context) *kopia.Kopia {
+func newKopiaInstance(c *cli.Context) (*kopia.Kopia, error) {
 	logger.AppLogger(c.Context).V(1).Info("flag values",
-		"access-key-id", c.String("access-key-id"),
-		"secret-access-key", c.String("secret-access-key"),
-		"encryption-password", c.String("encryption-password"),
+		"storage-type", c.String("storage-type"),
 		"endpoint", c.String("s3-endpoint"),
-		"bucket", c.String("bucket"))
-	return kopia.New(c.Context, c.Path("config"),
-		c.String("access-key-id"),
-		c.String("secret-access-key"),
-		c.String("encryption-password"),
-		c.String("s3-endpoint"),
-		c.String("bucket"),
+		"bucket", c.String("bucket"),
+		"prefix", c.String("s3-prefix"),
+		"filesystem-path", c.String("filesystem-path"),
+		"sftp-host", c.String("sftp-host"),
+		"sftp-path", c.String("sftp-path"),
+		"azure-container", c.String("azure-container"),
+		"gcs-bucket", c.String("gcs-bucket"),
+		"b2-bucket", c.String("b2-bucket"))
+	repository, err := k8s.RepositoryFromFlags(c)
+	if err != nil {
+		return nil, err
+	}
+	return newKopiaInstanceWith(c, repository)
+}
+
+// newKopiaInstanceWith returns a kopia instance for the given repository instead of the one from the flags.
+func newKopiaInstanceWith(c *cli.Context, repository *k8s.Repository) (*kopia.Kopia, error) {
+	return newKopiaInstanceAs(c, repository, c.Path("config"), c.Path("cache-path"), c.String("hostname"))
+}
+
+// newKopiaInstanceAs returns a kopia instance for the given repository that connects with the given hostname.
+// As the hostname is part of the kopia config, it needs its own config path.
+func newKopiaInstanceAs(c *cli.Context, repository *k8s.Repository, configPath, cachePath, hostname string) (*kopia.Kopia, error) {
+	k, err := kopia.New(c.Context, configPath,
+		repository.Storage(),
+		repository.EncryptionPassword,
 		c.Path("kopia-bin-path"),
-		c.String("hostname"),
-		c.Path("cache-path"))
+		hostname,
+		cachePath,
+		c.Bool("create-if-missing"))
+	if errors.Is(err, kopia.ErrRepositoryNotInitialized) {
+		return nil, fmt.Errorf("%w, use --create-if-missing to create it", err)
+	}
+	return k, err
 }
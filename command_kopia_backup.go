@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
 	"github.com/urfave/cli/v2"
 )
 
@@ -37,12 +41,89 @@ func newKopiaBackupCommand() *cli.Command {
 				EnvVars: []string{"HOSTNAME"},
 				Value:   hostname,
 			},
+			&cli.IntFlag{
+				Name:    "max-failed-files",
+				Usage:   "Fail the backup if more files than this couldn't be backed up, -1 disables the check",
+				EnvVars: envVars("MAX_FAILED_FILES"),
+				Value:   -1,
+			},
+			&cli.PathFlag{
+				Name:    "result-file",
+				Usage:   "Path where the result of the backup is written to as JSON",
+				EnvVars: envVars("RESULT_FILE"),
+			},
 		},
 	}
 }
 
 func runBackup(c *cli.Context) error {
-	kopia := newKopiaInstance(c)
-	kopia.Backup(c.Path("path"))
-	return kopia.LastExitCode
+	kopia, err := newKopiaInstance(c)
+	if err != nil {
+		return err
+	}
+
+	policy := retentionPolicyFromFlags(c)
+	if !policy.IsEmpty() {
+		err := kopia.SetRetentionPolicy(c.Path("path"), policy)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := kopia.Backup(c.Path("path"))
+	if result != nil {
+		logBackupResult(c, result)
+		if c.Path("result-file") != "" {
+			writeErr := writeBackupResult(c.Path("result-file"), result)
+			if writeErr != nil {
+				logger.AppLogger(c.Context).Error(writeErr, "cannot write backup result", "path", c.Path("result-file"))
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	maxFailed := c.Int("max-failed-files")
+	if maxFailed >= 0 && result.FailedFiles > maxFailed {
+		return fmt.Errorf("%d files failed to back up, only %d are allowed", result.FailedFiles, maxFailed)
+	}
+	return nil
+}
+
+func logBackupResult(c *cli.Context, result *kopia.BackupResult) {
+	log := logger.AppLogger(c.Context).WithName("backup")
+	log.Info("backup finished",
+		"snapshotID", result.SnapshotID,
+		"source", result.Source,
+		"size", result.Size,
+		"files", result.Files,
+		"dirs", result.Dirs,
+		"duration", result.Duration.String(),
+		"failedFiles", result.FailedFiles,
+		"ignoredErrors", result.IgnoredErrors)
+	for _, entryErr := range result.Errors {
+		log.Error(nil, "file could not be backed up", "path", entryErr.Path, "error", entryErr.Error)
+	}
+}
+
+// maxTerminationMessageSize is the limit kubernetes imposes on termination messages.
+// The backup jobs write their result as termination message.
+const maxTerminationMessageSize = 4096
+
+func writeBackupResult(path string, result *kopia.BackupResult) error {
+	out, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if len(out) > maxTerminationMessageSize {
+		// The list of errors is already logged, drop it so the result stays parsable.
+		trimmed := *result
+		trimmed.Errors = nil
+		out, err = json.Marshal(trimmed)
+		if err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, out, os.FileMode(0644))
 }
@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"path"
 	"strings"
+	"time"
 
+	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
 	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
 	"github.com/urfave/cli/v2"
 )
@@ -15,21 +18,90 @@ func newKopiaMaintenanceCommand() *cli.Command {
 		Name:   "maintenance",
 		Usage:  "Runs the maintenance on the given repository",
 		Action: runMaintenance,
-		Flags:  getKopiaParams(),
+		Flags:  append(getMaintenanceParams(), getKopiaParams()...),
+	}
+}
+
+// getMaintenanceParams returns the flags that configure the maintenance.
+func getMaintenanceParams() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "maintenance-identity",
+			Usage:   "Stable identity of the maintenance owner, the owner is kopia-k8s@<identity>. All instances that maintain the same repository have to use the same",
+			EnvVars: envVars("MAINTENANCE_IDENTITY"),
+			Value:   "maintenance",
+		},
+		&cli.BoolFlag{
+			Name:    "full",
+			Usage:   "Run the full maintenance even if its interval hasn't elapsed yet",
+			EnvVars: envVars("MAINTENANCE_FULL"),
+		},
+		&cli.DurationFlag{
+			Name:    "quick-maintenance-interval",
+			Usage:   "Interval of the quick maintenance",
+			EnvVars: envVars("QUICK_MAINTENANCE_INTERVAL"),
+			Value:   time.Hour,
+		},
+		&cli.DurationFlag{
+			Name:    "full-maintenance-interval",
+			Usage:   "Interval of the full maintenance, it runs instead of the quick one once the interval has elapsed",
+			EnvVars: envVars("FULL_MAINTENANCE_INTERVAL"),
+			Value:   24 * time.Hour,
+		},
+		&cli.StringFlag{
+			Name:    "safety",
+			Usage:   "Safety level of the maintenance, \"none\" reclaims space faster but is only safe if nothing else writes to the repository (values: [full, none])",
+			EnvVars: envVars("MAINTENANCE_SAFETY"),
+			Value:   kopia.SafetyFull,
+		},
 	}
 }
 
 func runMaintenance(c *cli.Context) error {
+	repository, err := k8s.RepositoryFromFlags(c)
+	if err != nil {
+		return err
+	}
+	return maintainRepository(c, repository)
+}
+
+// maintainRepository runs the maintenance with kopia-k8s@<maintenance-identity> as owner.
+// The identity has to stay the same across runs and pods, otherwise each run takes over the ownership.
+func maintainRepository(c *cli.Context, repository *k8s.Repository) error {
 	logger := logger.AppLogger(c.Context).WithName("maintenance")
 	logger.Info("starting maintenance")
 
-	hostname, err := os.Hostname()
+	identity := strings.ToLower(c.String("maintenance-identity"))
+	if identity == "" {
+		return fmt.Errorf("no maintenance identity given")
+	}
+
+	// The maintenance runs as the owner, so it gets its own config.
+	// The operator maintains several repositories, each of them gets its own config and cache
+	// so concurrent runs for different repositories don't overwrite each other's connection.
+	k, err := newKopiaInstanceAs(c, repository,
+		path.Join(c.Path("config"), "maintenance", repository.ID()),
+		path.Join(c.Path("cache-path"), "maintenance", repository.ID()),
+		identity)
 	if err != nil {
 		return err
 	}
 
-	owner := strings.ToLower(fmt.Sprintf("%s@%s", "kopia-k8s", hostname))
+	result, err := k.RunMaintenance(kopia.MaintenanceOptions{
+		Owner:         fmt.Sprintf("%s@%s", kopia.Username, identity),
+		Full:          c.Bool("full"),
+		QuickInterval: c.Duration("quick-maintenance-interval"),
+		FullInterval:  c.Duration("full-maintenance-interval"),
+		Safety:        c.String("safety"),
+	})
+	if err != nil {
+		return err
+	}
 
-	kopia := newKopiaInstance(c)
-	return kopia.RunMaintenance(owner)
+	logger.Info("maintenance finished",
+		"full", result.Full,
+		"deletedBlobs", result.DeletedBlobs,
+		"reclaimedBytes", result.ReclaimedBytes,
+		"duration", result.Duration.String())
+	return nil
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"github.com/urfave/cli/v2"
+)
+
+func newKopiaRestoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "restore",
+		Usage:  "Restores a snapshot into the given path",
+		Action: runRestore,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "snapshot",
+				Usage:   "ID of the snapshot to restore or \"latest\" for the newest snapshot of the source",
+				EnvVars: envVars("RESTORE_SNAPSHOT"),
+				Value:   kopia.LatestSnapshot,
+			},
+			&cli.StringFlag{
+				Name:    "source-host",
+				Usage:   "Host of the backed up source, required for \"latest\"",
+				EnvVars: envVars("RESTORE_SOURCE_HOST"),
+			},
+			&cli.StringFlag{
+				Name:    "source-path",
+				Usage:   "Path of the backed up source, required for \"latest\"",
+				EnvVars: envVars("RESTORE_SOURCE_PATH"),
+			},
+			&cli.PathFlag{
+				Name:     "target",
+				Aliases:  []string{"t"},
+				Usage:    "Path where the snapshot should get restored to, required",
+				EnvVars:  envVars("RESTORE_TARGET"),
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:    "overwrite",
+				Usage:   "Overwrite existing files in the target",
+				EnvVars: envVars("RESTORE_OVERWRITE"),
+			},
+			&cli.BoolFlag{
+				Name:    "skip-existing",
+				Usage:   "Skip files that already exist in the target",
+				EnvVars: envVars("RESTORE_SKIP_EXISTING"),
+			},
+			&cli.BoolFlag{
+				Name:    "dry-run",
+				Usage:   "Only show what would get restored",
+				EnvVars: envVars("RESTORE_DRY_RUN"),
+			},
+			&cli.BoolFlag{
+				Name:    "verify",
+				Usage:   "Compare the number and size of the restored files with the snapshot, only meaningful for an empty target",
+				EnvVars: envVars("RESTORE_VERIFY"),
+			},
+		},
+	}
+}
+
+func runRestore(c *cli.Context) error {
+	k, err := newKopiaInstance(c)
+	if err != nil {
+		return err
+	}
+	_, err = k.Restore(kopia.RestoreOptions{
+		SnapshotID:   c.String("snapshot"),
+		SourceHost:   c.String("source-host"),
+		SourcePath:   c.String("source-path"),
+		TargetPath:   c.Path("target"),
+		Overwrite:    c.Bool("overwrite"),
+		SkipExisting: c.Bool("skip-existing"),
+		DryRun:       c.Bool("dry-run"),
+		Verify:       c.Bool("verify"),
+	})
+	return err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"github.com/urfave/cli/v2"
+)
+
+func newKopiaSnapshotsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "snapshots",
+		Usage: "Manages the snapshots in the repository",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "Lists the snapshots in the repository",
+				Action: runSnapshotsList,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "namespace",
+						Aliases: []string{"host", "n"},
+						Usage:   "Only list snapshots of the given namespace",
+						EnvVars: envVars("SNAPSHOTS_NAMESPACE"),
+					},
+					&cli.StringFlag{
+						Name:    "pvc",
+						Usage:   "Only list snapshots of the given PVC",
+						EnvVars: envVars("SNAPSHOTS_PVC"),
+					},
+					&cli.StringFlag{
+						Name:    "path",
+						Usage:   "Only list snapshots of the given source path, takes precedence over --pvc",
+						EnvVars: envVars("SNAPSHOTS_PATH"),
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "Output format (values: [table, json])",
+						EnvVars: envVars("SNAPSHOTS_OUTPUT"),
+						Value:   "table",
+					},
+				},
+			},
+		},
+	}
+}
+
+func runSnapshotsList(c *cli.Context) error {
+	sourcePath := c.String("path")
+	if sourcePath == "" && c.String("pvc") != "" {
+		sourcePath = path.Join("/data", c.String("pvc"))
+	}
+
+	k, err := newKopiaInstance(c)
+	if err != nil {
+		return err
+	}
+	snapshots, err := k.ListSnapshots(c.String("namespace"), sourcePath)
+	if err != nil {
+		return err
+	}
+
+	switch c.String("output") {
+	case "json":
+		return printJSON(c, snapshots)
+	case "table":
+		return printSnapshotTable(c, snapshots)
+	default:
+		return fmt.Errorf("unknown output format %q", c.String("output"))
+	}
+}
+
+func printJSON(c *cli.Context, v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.App.Writer, string(out))
+	return err
+}
+
+func printSnapshotTable(c *cli.Context, snapshots []kopia.Snapshot) error {
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAMESPACE\tPATH\tSTART TIME\tSIZE\tFILES\tDIRS\tERRORS")
+	for _, s := range snapshots {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\n",
+			s.ID,
+			s.Source.Host,
+			s.Source.Path,
+			s.StartTime.Local().Format(time.RFC3339),
+			s.RootEntry.Summ.Size,
+			s.RootEntry.Summ.Files,
+			s.RootEntry.Summ.Dirs,
+			s.RootEntry.Summ.NumFailed)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// maintenanceOverdueExitCode is returned by `kopia status` if the maintenance is overdue.
+const maintenanceOverdueExitCode = 2
+
+func newKopiaStatusCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "status",
+		Usage:  "Shows the status of the repository, exits with 2 if the maintenance is overdue",
+		Action: runStatus,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Output format (values: [table, json])",
+				EnvVars: envVars("STATUS_OUTPUT"),
+				Value:   "table",
+			},
+			&cli.DurationFlag{
+				Name:    "max-quick-maintenance-age",
+				Usage:   "The quick maintenance is overdue if it didn't succeed for this long, 0 disables the check",
+				EnvVars: envVars("MAX_QUICK_MAINTENANCE_AGE"),
+				Value:   48 * time.Hour,
+			},
+			&cli.DurationFlag{
+				Name:    "max-full-maintenance-age",
+				Usage:   "The full maintenance is overdue if it didn't succeed for this long, 0 disables the check",
+				EnvVars: envVars("MAX_FULL_MAINTENANCE_AGE"),
+			},
+			&cli.BoolFlag{
+				Name:    "blob-stats",
+				Usage:   "Lists all blobs to show their number and total size, which is expensive for large repositories in object storages",
+				EnvVars: envVars("STATUS_BLOB_STATS"),
+			},
+		},
+	}
+}
+
+func runStatus(c *cli.Context) error {
+	k, err := newKopiaInstance(c)
+	if err != nil {
+		return err
+	}
+	status, err := k.GetStatus()
+	if err != nil {
+		return err
+	}
+	// A failed listing must not hide an overdue maintenance, so its error is only returned at the end.
+	var blobStatsErr error
+	if c.Bool("blob-stats") {
+		blobStatsErr = k.AddBlobStats(status)
+	}
+
+	switch c.String("output") {
+	case "json":
+		err = printJSON(c, status)
+	case "table":
+		err = printStatusTable(c, status)
+	default:
+		return fmt.Errorf("unknown output format %q", c.String("output"))
+	}
+	if err != nil {
+		return err
+	}
+
+	overdue := overdueMaintenance(c, status, time.Now())
+	if len(overdue) > 0 {
+		logger.AppLogger(c.Context).WithName("status").Info("maintenance is overdue", "maintenance", overdue)
+		return cli.Exit(fmt.Sprintf("%s maintenance is overdue", strings.Join(overdue, " and ")), maintenanceOverdueExitCode)
+	}
+	if blobStatsErr != nil {
+		return fmt.Errorf("cannot list blobs: %w", blobStatsErr)
+	}
+	return nil
+}
+
+// overdueMaintenance returns which maintenance didn't succeed within its maximum age.
+func overdueMaintenance(c *cli.Context, status *kopia.Status, now time.Time) []string {
+	overdue := []string{}
+	if maxAge := c.Duration("max-quick-maintenance-age"); maxAge > 0 && now.Sub(status.LastQuickMaintenance) > maxAge {
+		overdue = append(overdue, "quick")
+	}
+	if maxAge := c.Duration("max-full-maintenance-age"); maxAge > 0 && now.Sub(status.LastFullMaintenance) > maxAge {
+		overdue = append(overdue, "full")
+	}
+	return overdue
+}
+
+func printStatusTable(c *cli.Context, status *kopia.Status) error {
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "FORMAT VERSION:\t%d\n", status.FormatVersion)
+	fmt.Fprintf(w, "ENCRYPTION:\t%s\n", status.Encryption)
+	fmt.Fprintf(w, "HASH:\t%s\n", status.Hash)
+	if status.BlobCount != nil && status.TotalSize != nil {
+		fmt.Fprintf(w, "BLOBS:\t%d\n", *status.BlobCount)
+		fmt.Fprintf(w, "TOTAL SIZE:\t%d\n", *status.TotalSize)
+	}
+	fmt.Fprintf(w, "MAINTENANCE OWNER:\t%s\n", status.MaintenanceOwner)
+	fmt.Fprintf(w, "LAST QUICK MAINTENANCE:\t%s\n", formatTime(status.LastQuickMaintenance))
+	fmt.Fprintf(w, "LAST FULL MAINTENANCE:\t%s\n", formatTime(status.LastFullMaintenance))
+	fmt.Fprintf(w, "NEXT QUICK MAINTENANCE:\t%s\n", formatTime(status.NextQuickMaintenance))
+	fmt.Fprintf(w, "NEXT FULL MAINTENANCE:\t%s\n", formatTime(status.NextFullMaintenance))
+	return w.Flush()
+}
+
+// formatTime formats t for tables, the zero time means something never happened.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.RFC3339)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// verifyFailedExitCode is returned by `kopia verify` if kopia found errors in the snapshots.
+const verifyFailedExitCode = 3
+
+func newKopiaVerifyCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "verify",
+		Usage:  "Verifies that the snapshots in the repository can be restored, exits with 3 if there are errors",
+		Action: runVerify,
+		Flags: append([]cli.Flag{
+			&cli.PathFlag{
+				Name:    "result-file",
+				Usage:   "Path where the report of the verification is written to as JSON",
+				EnvVars: envVars("RESULT_FILE"),
+			},
+		}, getVerifyParams()...),
+	}
+}
+
+// getVerifyParams returns the flags that configure the verification.
+func getVerifyParams() []cli.Flag {
+	return []cli.Flag{
+		&cli.Float64Flag{
+			Name:    "verify-files-percent",
+			Usage:   "Percentage of files whose contents get read, 0 only verifies the metadata",
+			EnvVars: envVars("VERIFY_FILES_PERCENT"),
+		},
+		&cli.DurationFlag{
+			Name:    "verify-max-duration",
+			Usage:   "Stop the verification after this time and report what was verified until then, 0 disables the limit",
+			EnvVars: envVars("VERIFY_MAX_DURATION"),
+		},
+	}
+}
+
+func verifyOptionsFromFlags(c *cli.Context) kopia.VerifyOptions {
+	return kopia.VerifyOptions{
+		FilesPercent: c.Float64("verify-files-percent"),
+		MaxDuration:  c.Duration("verify-max-duration"),
+	}
+}
+
+func runVerify(c *cli.Context) error {
+	k, err := newKopiaInstance(c)
+	if err != nil {
+		return err
+	}
+
+	report, err := k.Verify(verifyOptionsFromFlags(c))
+	if report == nil {
+		return err
+	}
+	logVerifyReport(c, report)
+	if c.Path("result-file") != "" {
+		writeErr := writeVerifyReport(c.Path("result-file"), report)
+		if writeErr != nil {
+			logger.AppLogger(c.Context).Error(writeErr, "cannot write verify report", "path", c.Path("result-file"))
+		}
+	}
+
+	if report.Failed() {
+		return cli.Exit(fmt.Sprintf("verification found %d errors in %d snapshots", report.ErrorCount, len(report.AffectedSnapshots)), verifyFailedExitCode)
+	}
+	return err
+}
+
+func logVerifyReport(c *cli.Context, report *kopia.VerifyReport) {
+	log := logger.AppLogger(c.Context).WithName("verify")
+	log.Info("verification finished",
+		"objectsVerified", report.ObjectsVerified,
+		"filesPercent", report.FilesPercent,
+		"errors", report.ErrorCount,
+		"affectedSnapshots", report.AffectedSnapshots,
+		"duration", report.Duration.String(),
+		"incomplete", report.Incomplete)
+	for _, entryErr := range report.Errors {
+		log.Error(nil, "object could not be verified", "path", entryErr.Path, "error", entryErr.Error)
+	}
+}
+
+func writeVerifyReport(path string, report *kopia.VerifyReport) error {
+	out, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	if len(out) > maxTerminationMessageSize {
+		// The list of errors is already logged, drop it so the report stays parsable.
+		trimmed := *report
+		trimmed.Errors = nil
+		out, err = json.Marshal(trimmed)
+		if err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, out, os.FileMode(0644))
+}
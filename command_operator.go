@@ -1,7 +1,15 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"github.com/google/uuid"
 	"github.com/urfave/cli/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var operatorCommandName = "operator"
@@ -10,8 +18,122 @@ func newOperatorCommand() *cli.Command {
 	return &cli.Command{
 		Name:  operatorCommandName,
 		Usage: "Runs operator commands",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "metrics-bind-address",
+				Value:   ":8080",
+				Usage:   "The address the metric endpoint binds to, \"0\" disables it",
+				EnvVars: envVars("METRICS_BIND_ADDRESS"),
+			},
+			&cli.StringFlag{
+				Name:    "health-probe-bind-address",
+				Value:   ":8081",
+				Usage:   "The address the probe endpoint binds to",
+				EnvVars: envVars("HEALTH_PROBE_BIND_ADDRESS"),
+			},
+			&cli.BoolFlag{
+				Name:    "leader-elect",
+				Usage:   "Enable leader election for the operator",
+				EnvVars: envVars("LEADER_ELECT"),
+			},
+		},
 		Subcommands: []*cli.Command{
 			newOperatorBackupCommand(),
+			newOperatorRestoreCommand(),
+			newOperatorRestoreTestCommand(),
+			newOperatorRunCommand(),
+			newOperatorVerifyCommand(),
+		},
+	}
+}
+
+// getRepositoryParams returns the flags that select a Repository resource and how the jobs get its credentials.
+func getRepositoryParams() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "repository",
+			Usage:   "Name of the Repository resource to use instead of the repository flags",
+			EnvVars: envVars("REPOSITORY"),
+		},
+		&cli.StringFlag{
+			Name:    "repository-namespace",
+			Value:   "default",
+			Usage:   "Namespace of the Repository resource",
+			EnvVars: envVars("REPOSITORY_NAMESPACE"),
+		},
+		&cli.StringFlag{
+			Name:    "filesystem-pvc",
+			Usage:   "PVC with the repository for the filesystem storage, it's mounted into the jobs and has to exist in each namespace",
+			EnvVars: envVars("FILESYSTEM_PVC"),
+		},
+		&cli.StringFlag{
+			Name:    "filesystem-nfs-server",
+			Usage:   "NFS server with the repository for the filesystem storage, it's mounted into the jobs",
+			EnvVars: envVars("FILESYSTEM_NFS_SERVER"),
+		},
+		&cli.StringFlag{
+			Name:    "filesystem-nfs-path",
+			Value:   "/",
+			Usage:   "Exported path on the NFS server",
+			EnvVars: envVars("FILESYSTEM_NFS_PATH"),
+		},
+		&cli.StringFlag{
+			Name:    "credentials-secret",
+			Usage:   "Name of an existing secret in each namespace that contains the repository credentials, by default a secret is created for each run",
+			EnvVars: envVars("CREDENTIALS_SECRET"),
 		},
 	}
 }
+
+// resolveRepository returns the Repository resource given with the --repository flag.
+// Without it, the repository configured with the kopia flags is returned.
+func resolveRepository(c *cli.Context, k8sClient client.Client) (*k8s.Repository, error) {
+	if c.String("repository") == "" {
+		repository, err := k8s.RepositoryFromFlags(c)
+		if err != nil {
+			return nil, err
+		}
+		if repository.Type == kopia.StorageFilesystem && repository.Volume == nil {
+			return nil, fmt.Errorf("the filesystem storage needs either --filesystem-pvc or --filesystem-nfs-server")
+		}
+		return repository, nil
+	}
+	return k8s.ResolveRepository(c.Context, k8sClient, client.ObjectKey{
+		Namespace: c.String("repository-namespace"),
+		Name:      c.String("repository"),
+	})
+}
+
+// maintainRepositoryWithLease runs the maintenance if it can take the maintenance lease of the repository.
+// If another instance holds it, the maintenance is skipped.
+func maintainRepositoryWithLease(c *cli.Context, k8sClient client.Client, repository *k8s.Repository) error {
+	log := logger.AppLogger(c.Context).WithName("maintenance")
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	lease := &k8s.MaintenanceLease{
+		Client:    k8sClient,
+		Namespace: c.String("maintenance-lease-namespace"),
+		Name:      k8s.MaintenanceLeaseName(repository),
+		Holder:    fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8]),
+		Duration:  c.Duration("maintenance-lease-duration"),
+	}
+	acquired, currentHolder, err := lease.TryAcquire(c.Context)
+	if err != nil {
+		return fmt.Errorf("cannot acquire maintenance lease %s/%s: %w", lease.Namespace, lease.Name, err)
+	}
+	if !acquired {
+		log.Info("skipping maintenance, another instance holds the lease", "lease", lease.Name, "namespace", lease.Namespace, "holder", currentHolder)
+		return nil
+	}
+	defer func() {
+		err := lease.Release(c.Context)
+		if err != nil {
+			log.Error(err, "cannot release maintenance lease", "lease", lease.Name, "namespace", lease.Namespace)
+		}
+	}()
+
+	return maintainRepository(c, repository)
+}
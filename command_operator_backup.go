@@ -1,10 +1,16 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
 	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"git.earthnet.ch/simon.beck/kopia-k8s/metrics"
 	"github.com/google/uuid"
 	"github.com/urfave/cli/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func newOperatorBackupCommand() *cli.Command {
@@ -15,57 +21,251 @@ func newOperatorBackupCommand() *cli.Command {
 		Action: runOperatorBackup,
 		Flags: append([]cli.Flag{
 			&cli.StringFlag{
-				Name:    "pre-backup-annotation",
-				Value:   "kopia.earthnet.ch/prebackup",
-				Usage:   "The annotation that contains the pre-backup command",
-				EnvVars: envVars("PRE_BACKUP_ANNOTATION"),
-			},
-			&cli.IntFlag{
-				Name:    "concurrency",
-				Value:   3,
-				Usage:   "How many backup pods should run at the same time",
-				EnvVars: envVars("CONCURRENCY"),
+				Name:    "pushgateway-url",
+				Usage:   "URL of a Prometheus pushgateway, where the metrics of the run are pushed to",
+				EnvVars: envVars("PUSHGATEWAY_URL"),
 			},
 			&cli.StringFlag{
-				Name:    "uuid",
-				Value:   uuid.New().String(),
-				Usage:   "Random ID for jobs, so that each kopia-k8s instance can operate on their own jobs",
-				EnvVars: envVars("UUID"),
+				Name:    "pushgateway-job",
+				Value:   "kopia-k8s",
+				Usage:   "Job label for the metrics pushed to the pushgateway",
+				EnvVars: envVars("PUSHGATEWAY_JOB"),
 			},
-		}, getKopiaParams()...),
+			&cli.StringSliceFlag{
+				Name:    "pushgateway-grouping",
+				Usage:   "Additional grouping labels for the pushgateway in the form key=value, can be repeated",
+				EnvVars: envVars("PUSHGATEWAY_GROUPING"),
+			},
+		}, getOperatorBackupParams()...),
+	}
+}
+
+// getOperatorBackupParams returns the flags needed to schedule backup jobs.
+func getOperatorBackupParams() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:    "pre-backup-annotation",
+			Value:   "kopia.earthnet.ch/prebackup",
+			Usage:   "The annotation that contains the pre-backup command",
+			EnvVars: envVars("PRE_BACKUP_ANNOTATION"),
+		},
+		&cli.StringFlag{
+			Name:    "post-backup-annotation",
+			Value:   "kopia.earthnet.ch/postbackup",
+			Usage:   "The annotation that contains the post-backup command, it runs once all backup jobs of the pod have ended, even if they failed",
+			EnvVars: envVars("POST_BACKUP_ANNOTATION"),
+		},
+		&cli.StringFlag{
+			Name:    "post-backup-failure-policy",
+			Value:   k8s.PostBackupFailurePolicyFail,
+			Usage:   "Whether a failed post-backup command fails the run, the other post-backup commands run in any case (values: [fail, ignore])",
+			EnvVars: envVars("POST_BACKUP_FAILURE_POLICY"),
+		},
+		&cli.StringFlag{
+			Name:    "backup-unmounted-annotation",
+			Value:   "kopia.earthnet.ch/backup-unmounted",
+			Usage:   "PVCs that aren't mounted by a pod are skipped if they have this annotation set to \"false\"",
+			EnvVars: envVars("BACKUP_UNMOUNTED_ANNOTATION"),
+		},
+		&cli.StringFlag{
+			Name:    "retention-annotation",
+			Value:   "kopia.earthnet.ch/retention",
+			Usage:   "The annotation on PVCs and namespaces that overrides the retention flags, e.g. \"keep-daily=7,keep-weekly=4\"",
+			EnvVars: envVars("RETENTION_ANNOTATION"),
+		},
+		&cli.StringFlag{
+			Name:    "backup-annotation",
+			Value:   "kopia.earthnet.ch/backup",
+			Usage:   "Namespaces, pods and PVCs with this annotation set to \"false\" are skipped. With --opt-in, PVCs are only backed up if it's set to \"true\" on them or their pod",
+			EnvVars: envVars("BACKUP_ANNOTATION"),
+		},
+		&cli.BoolFlag{
+			Name:    "opt-in",
+			Usage:   "Only back up PVCs that have the backup annotation set to \"true\", either on themselves or on the pod that mounts them",
+			EnvVars: envVars("OPT_IN"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "include-namespace",
+			Usage:   "Only back up namespaces that match this glob pattern, can be repeated",
+			EnvVars: envVars("INCLUDE_NAMESPACE"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "exclude-namespace",
+			Usage:   "Skip namespaces that match this glob pattern, can be repeated. Takes precedence over --include-namespace",
+			EnvVars: envVars("EXCLUDE_NAMESPACE"),
+		},
+		&cli.StringFlag{
+			Name:    "namespace-selector",
+			Usage:   "Only back up namespaces that match this label selector, e.g. \"backup=true\"",
+			EnvVars: envVars("NAMESPACE_SELECTOR"),
+		},
+		&cli.StringFlag{
+			Name:    "pvc-selector",
+			Usage:   "Only back up PVCs that match this label selector, e.g. \"app!=cache\"",
+			EnvVars: envVars("PVC_SELECTOR"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "include-storage-class",
+			Usage:   "Only back up PVCs whose storage class matches this glob pattern, can be repeated",
+			EnvVars: envVars("INCLUDE_STORAGE_CLASS"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "exclude-storage-class",
+			Usage:   "Skip PVCs whose storage class matches this glob pattern, can be repeated. Takes precedence over --include-storage-class",
+			EnvVars: envVars("EXCLUDE_STORAGE_CLASS"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "exclude-access-mode",
+			Usage:   "Skip PVCs with this access mode, can be repeated (values: [RWO, ROX, RWX, RWOP])",
+			EnvVars: envVars("EXCLUDE_ACCESS_MODE"),
+		},
+		&cli.IntFlag{
+			Name:    "concurrency",
+			Value:   3,
+			Usage:   "How many backup pods should run at the same time",
+			EnvVars: envVars("CONCURRENCY"),
+		},
+		&cli.StringFlag{
+			Name:    "maintenance-lease-namespace",
+			Value:   "default",
+			Usage:   "Namespace of the leases that ensure only one maintenance runs per repository",
+			EnvVars: envVars("MAINTENANCE_LEASE_NAMESPACE"),
+		},
+		&cli.DurationFlag{
+			Name:    "maintenance-lease-duration",
+			Value:   5 * time.Minute,
+			Usage:   "How long a maintenance lease is valid, it's renewed while the maintenance runs and taken over by others once it expired",
+			EnvVars: envVars("MAINTENANCE_LEASE_DURATION"),
+		},
+		&cli.StringFlag{
+			Name:    "uuid",
+			Value:   uuid.New().String(),
+			Usage:   "Random ID for jobs, so that each kopia-k8s instance can operate on their own jobs",
+			EnvVars: envVars("UUID"),
+		},
 	}
+	flags = append(flags, getRepositoryParams()...)
+	flags = append(flags, getMaintenanceParams()...)
+	flags = append(flags, getVerifyParams()...)
+	return append(flags, getKopiaParams()...)
 }
 
 func runOperatorBackup(c *cli.Context) error {
 	logger := logger.AppLogger(c.Context).WithName("operator")
 	logger.V(1).Info("starting operator")
 
+	stats := &metrics.RunStats{}
+	start := time.Now()
+	defer func() {
+		stats.Duration = time.Since(start)
+		pushRunStats(c, *stats)
+	}()
+
 	operator := newOperator(c)
 	mgr := operator.initManager()
 	operator.registerController(mgr)
 	operator.startManager(mgr)
 
-	pvcList, err := k8s.ListEligiblePVCs(c, mgr.GetClient())
+	_, err := runBackupFlow(c, mgr.GetClient(), k8s.BackupOptions{}, stats)
+	return err
+}
+
+// runBackupFlow runs the pre-backup commands, the backup jobs and finally the maintenance.
+// It returns all the jobs that ended.
+func runBackupFlow(c *cli.Context, k8sClient client.Client, opts k8s.BackupOptions, stats *metrics.RunStats) ([]k8s.FinishedJob, error) {
+	repository := opts.Repository
+	if repository == nil {
+		var err error
+		repository, err = resolveRepository(c, k8sClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	policy := c.String("post-backup-failure-policy")
+	if policy != k8s.PostBackupFailurePolicyFail && policy != k8s.PostBackupFailurePolicyIgnore {
+		return nil, fmt.Errorf("unknown post-backup failure policy %q", policy)
+	}
+
+	filter, err := k8s.FilterFromFlags(c, opts.Filter)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	pvcList, err := k8s.ListEligiblePVCs(c, k8sClient, filter)
+	if err != nil {
+		return nil, err
+	}
+	stats.PVCsDiscovered = len(pvcList.MountedPVCs) + len(pvcList.UnmountedPVCs.Items)
+
+	concurrency := c.Int("concurrency")
+	if opts.Concurrency > 0 {
+		concurrency = opts.Concurrency
 	}
 
 	jobRunner := k8s.JobRunner{
-		CliCtx:      c,
-		K8sClient:   mgr.GetClient(),
-		Concurrency: c.Int("concurrency"),
-		PvcList:     pvcList,
+		CliCtx:         c,
+		K8sClient:      k8sClient,
+		Concurrency:    concurrency,
+		PvcList:        pvcList,
+		Retention:      retentionPolicyFromFlags(c),
+		RunID:          opts.RunID,
+		Repository:     repository,
+		PostBackupPods: k8s.ListPostbackupPods(c, pvcList),
 	}
 
-	err = k8s.ExecutePrebackupCommand(c, mgr.GetClient())
+	err = k8s.ExecutePrebackupCommand(c, pvcList)
 	if err != nil {
-		return err
+		stats.PrebackupHookFailures++
+		// Some pods might already be frozen by their pre-backup command.
+		jobRunner.RunPostBackupCommands()
+		stats.PostbackupHookFailures = jobRunner.PostBackupFailures
+		return nil, err
 	}
 
 	err = jobRunner.RunAndWatchBackupJobs()
+	postBackupErr := jobRunner.RunPostBackupCommands()
+	stats.JobsSucceeded = jobRunner.CountFinished(k8s.JobSucceeded)
+	stats.JobsFailed = jobRunner.CountFinished(k8s.JobFailed)
+	stats.JobsSkippedPending = jobRunner.CountFinished(k8s.JobSkipped)
+	stats.PostbackupHookFailures = jobRunner.PostBackupFailures
 	if err != nil {
-		return err
+		return jobRunner.Finished, err
+	}
+
+	err = maintainRepositoryWithLease(c, k8sClient, repository)
+	if err != nil {
+		return jobRunner.Finished, err
+	}
+	return jobRunner.Finished, postBackupErr
+}
+
+// pushRunStats pushes the stats to the pushgateway, if one is configured.
+func pushRunStats(c *cli.Context, stats metrics.RunStats) {
+	if c.String("pushgateway-url") == "" {
+		return
+	}
+	log := logger.AppLogger(c.Context).WithName("pushgateway")
+
+	grouping := map[string]string{}
+	for _, label := range c.StringSlice("pushgateway-grouping") {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 {
+			log.Error(nil, "ignoring invalid grouping label, expected key=value", "label", label)
+			continue
+		}
+		grouping[kv[0]] = kv[1]
 	}
 
-	return runMaintenance(c)
+	pusher := metrics.Pusher{
+		URL:      c.String("pushgateway-url"),
+		Job:      c.String("pushgateway-job"),
+		Grouping: grouping,
+	}
+	err := pusher.Push(stats)
+	if err != nil {
+		log.Error(err, "cannot push metrics", "url", pusher.URL)
+		return
+	}
+	log.V(1).Info("pushed metrics", "url", pusher.URL, "stats", stats)
 }
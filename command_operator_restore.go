@@ -0,0 +1,95 @@
+package main
+
+import (
+	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+)
+
+func newOperatorRestoreCommand() *cli.Command {
+
+	return &cli.Command{
+		Name:   "restore",
+		Usage:  "Schedules a restore job for a PVC on the cluster",
+		Action: runOperatorRestore,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:     "namespace",
+				Aliases:  []string{"n"},
+				Usage:    "Namespace of the PVC, required",
+				EnvVars:  envVars("RESTORE_NAMESPACE"),
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "pvc",
+				Usage:    "Name of the backed up PVC, required",
+				EnvVars:  envVars("RESTORE_PVC"),
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:    "target-pvc",
+				Usage:   "Name of the PVC to restore into, defaults to the backed up PVC",
+				EnvVars: envVars("RESTORE_TARGET_PVC"),
+			},
+			&cli.StringFlag{
+				Name:    "snapshot",
+				Usage:   "ID of the snapshot to restore or \"latest\" for the newest snapshot of the PVC",
+				EnvVars: envVars("RESTORE_SNAPSHOT"),
+				Value:   kopia.LatestSnapshot,
+			},
+			&cli.BoolFlag{
+				Name:    "overwrite",
+				Usage:   "Overwrite existing files in the target PVC",
+				EnvVars: envVars("RESTORE_OVERWRITE"),
+			},
+			&cli.BoolFlag{
+				Name:    "skip-existing",
+				Usage:   "Skip files that already exist in the target PVC",
+				EnvVars: envVars("RESTORE_SKIP_EXISTING"),
+			},
+			&cli.StringFlag{
+				Name:    "uuid",
+				Value:   uuid.New().String(),
+				Usage:   "Random ID for jobs, so that each kopia-k8s instance can operate on their own jobs",
+				EnvVars: envVars("UUID"),
+			},
+		}, append(getRepositoryParams(), getKopiaParams()...)...),
+	}
+}
+
+func runOperatorRestore(c *cli.Context) error {
+	logger := logger.AppLogger(c.Context).WithName("operator")
+	logger.V(1).Info("starting operator")
+
+	operator := newOperator(c)
+	mgr := operator.initManager()
+	operator.registerController(mgr)
+	operator.startManager(mgr)
+
+	targetPVC := c.String("target-pvc")
+	if targetPVC == "" {
+		targetPVC = c.String("pvc")
+	}
+
+	repository, err := resolveRepository(c, mgr.GetClient())
+	if err != nil {
+		return err
+	}
+
+	jobRunner := k8s.JobRunner{
+		CliCtx:     c,
+		K8sClient:  mgr.GetClient(),
+		Repository: repository,
+	}
+
+	return jobRunner.RunAndWatchRestoreJob(k8s.RestoreRequest{
+		Namespace:    c.String("namespace"),
+		PVC:          c.String("pvc"),
+		TargetPVC:    targetPVC,
+		Snapshot:     c.String("snapshot"),
+		Overwrite:    c.Bool("overwrite"),
+		SkipExisting: c.Bool("skip-existing"),
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+)
+
+// restoreTestFailedExitCode is returned by `operator restore-test` if the test restore of any PVC failed.
+const restoreTestFailedExitCode = 3
+
+func newOperatorRestoreTestCommand() *cli.Command {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:    "namespace",
+			Aliases: []string{"n"},
+			Usage:   "Only test the PVCs of the given namespace",
+			EnvVars: envVars("RESTORE_TEST_NAMESPACE"),
+		},
+		&cli.StringFlag{
+			Name:    "pvc",
+			Usage:   "Only test the given PVC",
+			EnvVars: envVars("RESTORE_TEST_PVC"),
+		},
+		&cli.StringFlag{
+			Name:    "snapshot-selection",
+			Usage:   "Which snapshot of each PVC gets restored (values: [latest, random])",
+			EnvVars: envVars("RESTORE_TEST_SNAPSHOT_SELECTION"),
+			Value:   "latest",
+		},
+		&cli.StringFlag{
+			Name:    "storage-class",
+			Usage:   "Storage class of the scratch PVCs, defaults to the one of the backed up PVC",
+			EnvVars: envVars("RESTORE_TEST_STORAGE_CLASS"),
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output format (values: [table, json])",
+			EnvVars: envVars("RESTORE_TEST_OUTPUT"),
+			Value:   "table",
+		},
+		&cli.StringFlag{
+			Name:    "uuid",
+			Value:   uuid.New().String(),
+			Usage:   "Random ID for jobs, so that each kopia-k8s instance can operate on their own jobs",
+			EnvVars: envVars("UUID"),
+		},
+	}
+	flags = append(flags, getRepositoryParams()...)
+
+	return &cli.Command{
+		Name:   "restore-test",
+		Usage:  "Restores a snapshot of each PVC into a scratch PVC and verifies it, exits with 3 if a test failed",
+		Action: runOperatorRestoreTest,
+		Flags:  append(flags, getKopiaParams()...),
+	}
+}
+
+func runOperatorRestoreTest(c *cli.Context) error {
+	logger := logger.AppLogger(c.Context).WithName("operator")
+	logger.V(1).Info("starting operator")
+
+	selection := c.String("snapshot-selection")
+	if selection != "latest" && selection != "random" {
+		return fmt.Errorf("unknown snapshot selection %q", selection)
+	}
+	output := c.String("output")
+	if output != "table" && output != "json" {
+		return fmt.Errorf("unknown output format %q", output)
+	}
+
+	operator := newOperator(c)
+	mgr := operator.initManager()
+	operator.registerController(mgr)
+	operator.startManager(mgr)
+
+	repository, err := resolveRepository(c, mgr.GetClient())
+	if err != nil {
+		return err
+	}
+	k, err := newKopiaInstanceWith(c, repository)
+	if err != nil {
+		return err
+	}
+
+	sourcePath := ""
+	if c.String("pvc") != "" {
+		sourcePath = path.Join("/data", c.String("pvc"))
+	}
+	snapshots, err := k.ListSnapshots(c.String("namespace"), sourcePath)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("no snapshots found to test")
+	}
+
+	jobRunner := k8s.JobRunner{
+		CliCtx:     c,
+		K8sClient:  mgr.GetClient(),
+		Repository: repository,
+	}
+
+	results := []k8s.RestoreTestResult{}
+	failed := 0
+	for _, snapshot := range selectTestSnapshots(snapshots, selection) {
+		result := jobRunner.RunRestoreTest(k8s.RestoreTestRequest{
+			Namespace:    snapshot.Source.Host,
+			PVC:          path.Base(snapshot.Source.Path),
+			SnapshotID:   snapshot.ID,
+			SnapshotSize: snapshot.RootEntry.Summ.Size,
+			StorageClass: c.String("storage-class"),
+		})
+		logger.Info("restore test finished", "namespace", result.Namespace, "pvc", result.PVC, "snapshot", result.SnapshotID, "passed", result.Passed, "message", result.Message)
+		if !result.Passed {
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	if output == "json" {
+		err = printJSON(c, results)
+	} else {
+		err = printRestoreTestTable(c, results)
+	}
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("restore test failed for %d of %d PVCs", failed, len(results)), restoreTestFailedExitCode)
+	}
+	return nil
+}
+
+// selectTestSnapshots picks one snapshot per PVC, either the latest or a random one.
+// Only snapshots of PVCs are considered, their source path is /data/<pvcname>.
+func selectTestSnapshots(snapshots []kopia.Snapshot, selection string) []kopia.Snapshot {
+	sources := []kopia.Source{}
+	bySource := map[kopia.Source][]kopia.Snapshot{}
+	for _, snapshot := range snapshots {
+		if path.Dir(snapshot.Source.Path) != "/data" || strings.HasSuffix(snapshot.Source.Path, "/") {
+			continue
+		}
+		if _, ok := bySource[snapshot.Source]; !ok {
+			sources = append(sources, snapshot.Source)
+		}
+		bySource[snapshot.Source] = append(bySource[snapshot.Source], snapshot)
+	}
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	selected := []kopia.Snapshot{}
+	for _, source := range sources {
+		// The snapshots are sorted by their start time.
+		candidates := bySource[source]
+		if selection == "random" {
+			selected = append(selected, candidates[random.Intn(len(candidates))])
+			continue
+		}
+		selected = append(selected, candidates[len(candidates)-1])
+	}
+	return selected
+}
+
+func printRestoreTestTable(c *cli.Context, results []k8s.RestoreTestResult) error {
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPVC\tSNAPSHOT\tRESULT\tMESSAGE")
+	for _, r := range results {
+		result := "failed"
+		if r.Passed {
+			result = "passed"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Namespace, r.PVC, r.SnapshotID, result, r.Message)
+	}
+	return w.Flush()
+}
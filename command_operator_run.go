@@ -0,0 +1,29 @@
+package main
+
+import (
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"github.com/urfave/cli/v2"
+)
+
+func newOperatorRunCommand() *cli.Command {
+
+	return &cli.Command{
+		Name:   "run",
+		Usage:  "Runs the operator until it's stopped and handles the kopia-k8s custom resources",
+		Action: runOperatorRun,
+		Flags:  getOperatorBackupParams(),
+	}
+}
+
+func runOperatorRun(c *cli.Context) error {
+	logger := logger.AppLogger(c.Context).WithName("operator")
+	logger.V(1).Info("starting operator")
+
+	operator := newOperator(c)
+	operator.watchResources = true
+	operator.runOperator()
+
+	<-c.Context.Done()
+	logger.Info("shutting down operator")
+	return nil
+}
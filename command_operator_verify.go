@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+)
+
+func newOperatorVerifyCommand() *cli.Command {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:    "namespace",
+			Aliases: []string{"n"},
+			Value:   "default",
+			Usage:   "Namespace the verify job runs in",
+			EnvVars: envVars("VERIFY_NAMESPACE"),
+		},
+		&cli.StringFlag{
+			Name:    "uuid",
+			Value:   uuid.New().String(),
+			Usage:   "Random ID for jobs, so that each kopia-k8s instance can operate on their own jobs",
+			EnvVars: envVars("UUID"),
+		},
+	}
+	flags = append(flags, getVerifyParams()...)
+	flags = append(flags, getRepositoryParams()...)
+
+	return &cli.Command{
+		Name:   "verify",
+		Usage:  "Schedules a job that verifies the snapshots in the repository, exits with 3 if there are errors",
+		Action: runOperatorVerify,
+		Flags:  append(flags, getKopiaParams()...),
+	}
+}
+
+func runOperatorVerify(c *cli.Context) error {
+	logger := logger.AppLogger(c.Context).WithName("operator")
+	logger.V(1).Info("starting operator")
+
+	operator := newOperator(c)
+	mgr := operator.initManager()
+	operator.registerController(mgr)
+	operator.startManager(mgr)
+
+	repository, err := resolveRepository(c, mgr.GetClient())
+	if err != nil {
+		return err
+	}
+
+	jobRunner := k8s.JobRunner{
+		CliCtx:     c,
+		K8sClient:  mgr.GetClient(),
+		Repository: repository,
+	}
+
+	report, err := jobRunner.RunAndWatchVerifyJob(c.String("namespace"), verifyOptionsFromFlags(c))
+	if report == nil {
+		return err
+	}
+	logVerifyReport(c, report)
+	if report.Failed() {
+		return cli.Exit(fmt.Sprintf("verification found %d errors in %d snapshots", report.ErrorCount, len(report.AffectedSnapshots)), verifyFailedExitCode)
+	}
+	return err
+}
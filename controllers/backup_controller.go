@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	kopiav1alpha1 "git.earthnet.ch/simon.beck/kopia-k8s/api/v1alpha1"
+	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// BackupFunc runs a backup with the given options and returns the jobs that ran.
+type BackupFunc func(ctx context.Context, opts k8s.BackupOptions) ([]k8s.FinishedJob, error)
+
+// BackupReconciler reconciles a Backup object
+type BackupReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// RunBackup runs the actual backup.
+	// Only one backup runs at a time, as the reconciler blocks until it's done.
+	RunBackup BackupFunc
+}
+
+//+kubebuilder:rbac:groups=kopia.earthnet.ch,resources=backups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kopia.earthnet.ch,resources=backups/status,verbs=get;update;patch
+
+// Reconcile is the entrypoint to manage the given resource.
+func (r *BackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	backup := &kopiav1alpha1.Backup{}
+
+	err := r.Client.Get(ctx, req.NamespacedName, backup)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if backup.Status.FinishTime != nil || backup.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	if backup.Status.StartTime != nil {
+		// Backups run synchronously, so a started but unfinished backup means the operator stopped during the run.
+		r.Log.Info("backup was interrupted", "name", backup.Name, "namespace", backup.Namespace)
+		return ctrl.Result{}, r.finish(ctx, backup, kopiav1alpha1.ReasonInterrupted, "the operator stopped during the backup")
+	}
+
+	opts, err := r.backupOptions(ctx, backup)
+	if err != nil {
+		return ctrl.Result{}, r.finish(ctx, backup, kopiav1alpha1.ReasonFailed, err.Error())
+	}
+
+	now := metav1.Now()
+	backup.Status.StartTime = &now
+	meta.SetStatusCondition(&backup.Status.Conditions, metav1.Condition{
+		Type:    kopiav1alpha1.ConditionCompleted,
+		Status:  metav1.ConditionFalse,
+		Reason:  kopiav1alpha1.ReasonRunning,
+		Message: "backup is running",
+	})
+	err = r.Client.Status().Update(ctx, backup)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	r.Log.Info("starting backup", "name", backup.Name, "namespace", backup.Namespace)
+	finished, err := r.RunBackup(ctx, opts)
+	backup.Status.Results = pvcResults(finished)
+
+	if err != nil {
+		r.Log.Error(err, "backup failed", "name", backup.Name, "namespace", backup.Namespace)
+		return ctrl.Result{}, r.finish(ctx, backup, kopiav1alpha1.ReasonFailed, err.Error())
+	}
+	if failed := failedPVCs(finished); len(failed) > 0 {
+		return ctrl.Result{}, r.finish(ctx, backup, kopiav1alpha1.ReasonFailed, fmt.Sprintf("backup of %s failed", strings.Join(failed, ", ")))
+	}
+	r.Log.Info("backup finished", "name", backup.Name, "namespace", backup.Namespace)
+	return ctrl.Result{}, r.finish(ctx, backup, kopiav1alpha1.ReasonSucceeded, "backup finished successfully")
+}
+
+func (r *BackupReconciler) backupOptions(ctx context.Context, backup *kopiav1alpha1.Backup) (k8s.BackupOptions, error) {
+	opts := k8s.BackupOptions{
+		RunID:       string(backup.UID),
+		Concurrency: backup.Spec.Concurrency,
+	}
+
+	if backup.Spec.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(backup.Spec.NamespaceSelector)
+		if err != nil {
+			return opts, fmt.Errorf("invalid namespace selector: %w", err)
+		}
+		opts.Filter.NamespaceSelector = selector
+	}
+	if backup.Spec.PVCSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(backup.Spec.PVCSelector)
+		if err != nil {
+			return opts, fmt.Errorf("invalid pvc selector: %w", err)
+		}
+		opts.Filter.PVCSelector = selector
+	}
+	if backup.Spec.RepositoryRef != nil {
+		repository, err := k8s.ResolveRepository(ctx, r.Client, client.ObjectKey{Namespace: backup.Namespace, Name: backup.Spec.RepositoryRef.Name})
+		if err != nil {
+			return opts, err
+		}
+		opts.Repository = repository
+	}
+	return opts, nil
+}
+
+// finish marks the backup as completed with the given reason.
+// The status is written to a freshly fetched object on conflicts, otherwise the requeue would find
+// the backup still running and mark the finished run as interrupted.
+func (r *BackupReconciler) finish(ctx context.Context, backup *kopiav1alpha1.Backup, reason, message string) error {
+	now := metav1.Now()
+	results := backup.Status.Results
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current := &kopiav1alpha1.Backup{}
+		err := r.Client.Get(ctx, client.ObjectKeyFromObject(backup), current)
+		if err != nil {
+			return err
+		}
+		current.Status.FinishTime = &now
+		if results != nil {
+			current.Status.Results = results
+		}
+		meta.SetStatusCondition(&current.Status.Conditions, metav1.Condition{
+			Type:    kopiav1alpha1.ConditionCompleted,
+			Status:  metav1.ConditionTrue,
+			Reason:  reason,
+			Message: message,
+		})
+		return r.Client.Status().Update(ctx, current)
+	})
+}
+
+func pvcResults(finished []k8s.FinishedJob) []kopiav1alpha1.PVCResult {
+	results := []kopiav1alpha1.PVCResult{}
+	for _, job := range finished {
+		result := kopiav1alpha1.PVCResult{
+			Namespace: job.Namespace,
+			PVC:       job.PVC,
+			Status:    string(job.Status),
+		}
+		if job.Result != nil {
+			result.SnapshotID = job.Result.SnapshotID
+			result.Size = job.Result.Size
+			result.Files = job.Result.Files
+			result.FailedFiles = job.Result.FailedFiles
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func failedPVCs(finished []k8s.FinishedJob) []string {
+	failed := []string{}
+	for _, job := range finished {
+		if job.Status == k8s.JobFailed {
+			failed = append(failed, fmt.Sprintf("%s/%s", job.Namespace, job.PVC))
+		}
+	}
+	return failed
+}
+
+// SetupWithManager configures the reconciler.
+func (r *BackupReconciler) SetupWithManager(mgr ctrl.Manager, l logr.Logger, uuid string) error {
+	r.Client = mgr.GetClient()
+	r.Scheme = mgr.GetScheme()
+	r.Log = l
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kopiav1alpha1.Backup{}).
+		Complete(r)
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kopiav1alpha1 "git.earthnet.ch/simon.beck/kopia-k8s/api/v1alpha1"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestBackupFinishRetriesOnConflict(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kopiav1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	started := metav1.Now()
+	backup := &kopiav1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "backup"},
+		Status:     kopiav1alpha1.BackupStatus{StartTime: &started},
+	}
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(backup).Build()
+	r := &BackupReconciler{Client: c, Log: logr.Discard(), Scheme: scheme}
+
+	stale := &kopiav1alpha1.Backup{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(backup), stale); err != nil {
+		t.Fatal(err)
+	}
+	// Someone else updates the backup while it runs, e.g. to add a label.
+	current := stale.DeepCopy()
+	current.Labels = map[string]string{"team": "a"}
+	if err := c.Update(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+
+	stale.Status.Results = []kopiav1alpha1.PVCResult{{Namespace: "default", PVC: "data", Status: "succeeded"}}
+	if err := r.finish(ctx, stale, kopiav1alpha1.ReasonSucceeded, "backup finished successfully"); err != nil {
+		t.Fatalf("finish failed: %v", err)
+	}
+
+	result := &kopiav1alpha1.Backup{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(backup), result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Status.FinishTime == nil {
+		t.Fatal("finish time wasn't set")
+	}
+	if condition := meta.FindStatusCondition(result.Status.Conditions, kopiav1alpha1.ConditionCompleted); condition == nil || condition.Reason != kopiav1alpha1.ReasonSucceeded {
+		t.Errorf("got condition %+v, want reason %s", condition, kopiav1alpha1.ReasonSucceeded)
+	}
+	if len(result.Status.Results) != 1 {
+		t.Errorf("got results %+v, want the one of the run", result.Status.Results)
+	}
+	if result.Labels["team"] != "a" {
+		t.Error("the concurrent update got lost")
+	}
+}
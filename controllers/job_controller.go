@@ -2,9 +2,12 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"git.earthnet.ch/simon.beck/kopia-k8s/metrics"
 	"github.com/go-logr/logr"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -13,6 +16,7 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -23,6 +27,9 @@ type JobReconciler struct {
 	Log    logr.Logger
 	Scheme *runtime.Scheme
 	uuid   string
+	// notified contains the UID of the jobs whose JobRunner was already notified.
+	// An entry is removed once the job is deleted.
+	notified map[client.ObjectKey]types.UID
 }
 
 // +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
@@ -35,6 +42,7 @@ func (r *JobReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	err := r.Client.Get(ctx, req.NamespacedName, myJob)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			delete(r.notified, req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
@@ -42,27 +50,49 @@ func (r *JobReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 
 	r.Log.V(1).Info("watched job", "name", req.Name, "status", myJob.Status)
 
-	if myJob.ObjectMeta.Labels[k8s.JobLabel] == r.uuid && myJob.DeletionTimestamp == nil {
-		if myJob.Status.Succeeded > 0 {
-			backgroundDelete := v1.DeletePropagationBackground
-			err := r.Client.Delete(ctx, myJob, &client.DeleteOptions{PropagationPolicy: &backgroundDelete})
-			if err != nil {
-				r.Log.Error(err, "job finished successfull, but cannot be cleaned up")
-			} else {
-				r.Log.Info("job finished successfully, cleaning up", "name", myJob.Name)
-			}
-			k8s.FinishedJobChannel <- true
+	if myJob.DeletionTimestamp != nil {
+		delete(r.notified, req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+	if uid, ok := r.notified[req.NamespacedName]; ok && uid == myJob.UID {
+		return ctrl.Result{}, nil
+	}
+	if myJob.ObjectMeta.Labels[k8s.JobLabel] != r.uuid {
+		return ctrl.Result{}, nil
+	}
+
+	pvcName := myJob.Annotations[k8s.PVCAnnotation]
+
+	if myJob.Status.Succeeded > 0 {
+		// The result has to be read before the job and its pods get deleted.
+		message := r.terminationMessage(ctx, myJob)
+		result := r.backupResult(myJob, message)
+		if result != nil {
+			metrics.RecordBackupResult(myJob.Namespace, pvcName, completionTime(myJob), result)
 		}
-		if myJob.Status.Failed > 0 {
-			r.Log.Error(nil, "job failed, not cleaning up", "name", myJob.Name)
-			k8s.FinishedJobChannel <- true
+
+		backgroundDelete := v1.DeletePropagationBackground
+		err := r.Client.Delete(ctx, myJob, &client.DeleteOptions{PropagationPolicy: &backgroundDelete})
+		if err != nil {
+			r.Log.Error(err, "job finished successfull, but cannot be cleaned up")
+		} else {
+			r.Log.Info("job finished successfully, cleaning up", "name", myJob.Name)
 		}
-		if myJob.Status.Active > 0 {
-			if time.Now().Sub(myJob.CreationTimestamp.Time).Minutes() > 15 {
-				if r.isJobPodPending(ctx, myJob) {
-					r.Log.Info("pod has been pending for over 5 minutes, skipping and starting next pod", "name", myJob.Name, "namespace", myJob.Namespace)
-					k8s.FinishedJobChannel <- true
-				}
+		r.notify(myJob, k8s.JobSucceeded, result, message)
+		return ctrl.Result{}, nil
+	}
+	if myJob.Status.Failed > 0 {
+		r.Log.Error(nil, "job failed, not cleaning up", "name", myJob.Name)
+		metrics.RecordFailedJob(myJob.Namespace, pvcName)
+		r.notify(myJob, k8s.JobFailed, nil, r.terminationMessage(ctx, myJob))
+		return ctrl.Result{}, nil
+	}
+	if myJob.Status.Active > 0 {
+		if time.Now().Sub(myJob.CreationTimestamp.Time).Minutes() > 15 {
+			if r.isJobPodPending(ctx, myJob) {
+				r.Log.Info("pod has been pending for over 5 minutes, skipping and starting next pod", "name", myJob.Name, "namespace", myJob.Namespace)
+				r.notify(myJob, k8s.JobSkipped, nil, "")
+				return ctrl.Result{}, nil
 			}
 		}
 	}
@@ -70,12 +100,86 @@ func (r *JobReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	return ctrl.Result{RequeueAfter: time.Minute * 1}, nil
 }
 
+// notify sends the job to the JobRunner that created it, but only once per job.
+func (r *JobReconciler) notify(job *batchv1.Job, status k8s.JobStatus, result *kopia.BackupResult, message string) {
+	r.notified[client.ObjectKeyFromObject(job)] = job.UID
+	notified := k8s.NotifyFinishedJob(k8s.FinishedJob{
+		Name:      job.Name,
+		Namespace: job.Namespace,
+		PVC:       job.Annotations[k8s.PVCAnnotation],
+		Status:    status,
+		Result:    result,
+		Message:   message,
+	})
+	if !notified {
+		r.Log.V(1).Info("no one waits for the job", "name", job.Name, "namespace", job.Namespace)
+	}
+}
+
+func completionTime(job *batchv1.Job) time.Time {
+	if job.Status.CompletionTime != nil {
+		return job.Status.CompletionTime.Time
+	}
+	return time.Now()
+}
+
+// backupResult parses the result a backup job wrote as termination message.
+// It returns nil for other jobs or if there's no result.
+func (r *JobReconciler) backupResult(myJob *batchv1.Job, message string) *kopia.BackupResult {
+	if myJob.Annotations[k8s.JobTypeAnnotation] != k8s.JobTypeBackup {
+		return nil
+	}
+	if message == "" {
+		r.Log.V(1).Info("backup job did not report a result", "name", myJob.Name, "namespace", myJob.Namespace)
+		return nil
+	}
+
+	result := &kopia.BackupResult{}
+	err := json.Unmarshal([]byte(message), result)
+	if err != nil {
+		r.Log.Error(err, "could not parse backup result", "name", myJob.Name, "namespace", myJob.Namespace)
+		return nil
+	}
+	return result
+}
+
+// terminationMessage returns the termination message of the kopia container of the job's last pod.
+func (r *JobReconciler) terminationMessage(ctx context.Context, myJob *batchv1.Job) string {
+	podList := &corev1.PodList{}
+	labelSelector, _ := createLabelSelector(myJob.Name)
+	err := r.Client.List(ctx, podList, client.InNamespace(myJob.Namespace), &client.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		r.Log.Error(err, "could not list pods to get the termination message", "name", myJob.Name, "namespace", myJob.Namespace)
+		return ""
+	}
+
+	message := ""
+	var finishedAt time.Time
+	for _, pod := range podList.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			terminated := status.State.Terminated
+			if status.Name != k8s.ContainerName || terminated == nil || terminated.Message == "" {
+				continue
+			}
+			// A successful job ends with a successful container.
+			if myJob.Status.Succeeded > 0 && terminated.ExitCode != 0 {
+				continue
+			}
+			if terminated.FinishedAt.Time.After(finishedAt) {
+				message = terminated.Message
+				finishedAt = terminated.FinishedAt.Time
+			}
+		}
+	}
+	return message
+}
+
 func (r *JobReconciler) isJobPodPending(ctx context.Context, myJob *batchv1.Job) bool {
 	podList := &corev1.PodList{}
 
 	labelSelector, _ := createLabelSelector(myJob.Name)
 
-	err := r.Client.List(ctx, podList, &client.ListOptions{LabelSelector: labelSelector})
+	err := r.Client.List(ctx, podList, client.InNamespace(myJob.Namespace), &client.ListOptions{LabelSelector: labelSelector})
 	if err != nil {
 		r.Log.Error(err, "could not list pod to determine pending state", "name", myJob.Name, "namespace", myJob.Namespace)
 		return false
@@ -105,6 +209,7 @@ func (r *JobReconciler) SetupWithManager(mgr ctrl.Manager, l logr.Logger, uuid s
 	r.Scheme = mgr.GetScheme()
 	r.Log = l
 	r.uuid = uuid
+	r.notified = map[client.ObjectKey]types.UID{}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&batchv1.Job{}).
 		Complete(r)
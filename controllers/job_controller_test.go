@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestJobNotifiedIsRemovedWithTheJob(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := batchv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "kopia-backup-data-abcdef",
+			UID:       types.UID("job-uid"),
+			Labels:    map[string]string{k8s.JobLabel: "uuid"},
+		},
+		Status: batchv1.JobStatus{Failed: 1},
+	}
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(job).Build()
+	r := &JobReconciler{Client: c, Log: logr.Discard(), Scheme: scheme, uuid: "uuid", notified: map[client.ObjectKey]types.UID{}}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(job)}
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if r.notified[req.NamespacedName] != job.UID {
+		t.Fatalf("got notified %v, want the failed job", r.notified)
+	}
+
+	if err := c.Delete(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.notified) != 0 {
+		t.Errorf("got notified %v after the job was deleted, want it empty", r.notified)
+	}
+}
@@ -0,0 +1,354 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	kopiav1alpha1 "git.earthnet.ch/simon.beck/kopia-k8s/api/v1alpha1"
+	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
+	"github.com/go-logr/logr"
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const defaultBackupHistoryLimit = 3
+
+// RunFunc runs a repository wide task like the maintenance.
+// The repository is nil if the one configured on the operator should be used.
+type RunFunc func(ctx context.Context, repository *k8s.Repository) error
+
+// ScheduleReconciler reconciles a Schedule object
+type ScheduleReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// RunMaintenance runs the repository maintenance.
+	RunMaintenance RunFunc
+	// RunCheck verifies the snapshots in the repository.
+	RunCheck RunFunc
+
+	// active contains the maintenance and check runs that are still in progress.
+	active      map[string]bool
+	activeMutex sync.Mutex
+	// runCtx is passed to the maintenance and check runs. It's canceled once the manager stops.
+	runCtx     context.Context
+	cancelRuns context.CancelFunc
+	runs       sync.WaitGroup
+}
+
+//+kubebuilder:rbac:groups=kopia.earthnet.ch,resources=schedules,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kopia.earthnet.ch,resources=schedules/status,verbs=get;update;patch
+
+// Reconcile is the entrypoint to manage the given resource.
+func (r *ScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	schedule := &kopiav1alpha1.Schedule{}
+
+	err := r.Client.Get(ctx, req.NamespacedName, schedule)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if schedule.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	if condition := meta.FindStatusCondition(schedule.Status.Conditions, kopiav1alpha1.ConditionScheduled); condition != nil &&
+		condition.Status == metav1.ConditionFalse && condition.ObservedGeneration == schedule.Generation {
+		// The spec is still invalid, there's nothing to do until it changes.
+		return ctrl.Result{}, nil
+	}
+
+	crons, err := parseCrons(schedule)
+	if err != nil {
+		r.Log.Error(err, "invalid schedule, waiting for the spec to change", "name", schedule.Name, "namespace", schedule.Namespace)
+		schedule.Status.Backup.NextTime = nil
+		schedule.Status.Maintenance.NextTime = nil
+		schedule.Status.Check.NextTime = nil
+		meta.SetStatusCondition(&schedule.Status.Conditions, metav1.Condition{
+			Type:               kopiav1alpha1.ConditionScheduled,
+			Status:             metav1.ConditionFalse,
+			Reason:             kopiav1alpha1.ReasonInvalidCron,
+			Message:            err.Error(),
+			ObservedGeneration: schedule.Generation,
+		})
+		return ctrl.Result{}, r.Client.Status().Update(ctx, schedule)
+	}
+	meta.SetStatusCondition(&schedule.Status.Conditions, metav1.Condition{
+		Type:               kopiav1alpha1.ConditionScheduled,
+		Status:             metav1.ConditionTrue,
+		Reason:             kopiav1alpha1.ReasonValid,
+		Message:            "all cron expressions are valid",
+		ObservedGeneration: schedule.Generation,
+	})
+
+	now := time.Now()
+	var requeueAfter time.Duration
+	// due contains the runs that get triggered once the new status is saved.
+	due := []dueRun{}
+
+	for _, run := range []struct {
+		name    string
+		cron    string
+		status  *kopiav1alpha1.ScheduledRun
+		trigger func(scheduled time.Time) error
+	}{
+		{"backup", schedule.Spec.Backup, &schedule.Status.Backup, func(scheduled time.Time) error { return r.triggerBackup(ctx, schedule, scheduled) }},
+		{"maintenance", schedule.Spec.Maintenance, &schedule.Status.Maintenance, func(time.Time) error { return r.triggerAsync(ctx, schedule, "maintenance", r.RunMaintenance) }},
+		{"check", schedule.Spec.Check, &schedule.Status.Check, func(time.Time) error { return r.triggerAsync(ctx, schedule, "check", r.RunCheck) }},
+	} {
+		cronSchedule := crons[run.name]
+		if cronSchedule == nil {
+			run.status.NextTime = nil
+			run.status.Cron = ""
+			continue
+		}
+
+		if run.status.Cron != run.cron {
+			// The next time was computed from an outdated cron expression.
+			run.status.NextTime = nil
+			run.status.Cron = run.cron
+		}
+
+		if run.status.NextTime != nil && !now.Before(run.status.NextTime.Time) {
+			due = append(due, dueRun{name: run.name, scheduled: run.status.NextTime.Time, trigger: run.trigger})
+			run.status.LastTime = &metav1.Time{Time: now}
+			run.status.NextTime = nil
+		}
+
+		if run.status.NextTime == nil {
+			run.status.NextTime = &metav1.Time{Time: cronSchedule.Next(now).Add(r.jitter(schedule))}
+		}
+
+		if next := run.status.NextTime.Sub(now); requeueAfter == 0 || next < requeueAfter {
+			requeueAfter = next
+		}
+	}
+
+	// The new status has to be saved before triggering the runs.
+	// Otherwise a conflict would trigger them again with the next reconcile.
+	err = r.Client.Status().Update(ctx, schedule)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for _, run := range due {
+		err := run.trigger(run.scheduled)
+		if err != nil {
+			r.Log.Error(err, "cannot trigger scheduled run", "name", schedule.Name, "namespace", schedule.Namespace, "run", run.name)
+		}
+	}
+
+	err = r.cleanupBackups(ctx, schedule)
+	if err != nil {
+		r.Log.Error(err, "cannot clean up old backups", "name", schedule.Name, "namespace", schedule.Namespace)
+	}
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// parseCrons parses the cron expressions of the schedule by run name.
+// Runs without a cron expression are missing.
+func parseCrons(schedule *kopiav1alpha1.Schedule) (map[string]cron.Schedule, error) {
+	crons := map[string]cron.Schedule{}
+	for _, run := range []struct {
+		name string
+		cron string
+	}{
+		{"backup", schedule.Spec.Backup},
+		{"maintenance", schedule.Spec.Maintenance},
+		{"check", schedule.Spec.Check},
+	} {
+		if run.cron == "" {
+			continue
+		}
+		parsed, err := cron.ParseStandard(run.cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s cron expression %q: %w", run.name, run.cron, err)
+		}
+		crons[run.name] = parsed
+	}
+	return crons, nil
+}
+
+// dueRun is a scheduled run whose time has come.
+type dueRun struct {
+	name      string
+	scheduled time.Time
+	trigger   func(scheduled time.Time) error
+}
+
+func (r *ScheduleReconciler) jitter(schedule *kopiav1alpha1.Schedule) time.Duration {
+	if schedule.Spec.Jitter == nil || schedule.Spec.Jitter.Duration <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(schedule.Spec.Jitter.Duration)))
+}
+
+// triggerBackup creates a new Backup, unless a Backup of the schedule is still running.
+// The name of the Backup is derived from the scheduled time, so each scheduled time creates at most one Backup.
+func (r *ScheduleReconciler) triggerBackup(ctx context.Context, schedule *kopiav1alpha1.Schedule, scheduled time.Time) error {
+	backups, err := r.listBackups(ctx, schedule)
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups {
+		if backup.Status.FinishTime == nil {
+			r.Log.Info("previous backup is still running, skipping", "name", schedule.Name, "namespace", schedule.Namespace, "backup", backup.Name)
+			return nil
+		}
+	}
+
+	backup := &kopiav1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%d", schedule.Name, scheduled.Unix()),
+			Namespace: schedule.Namespace,
+		},
+		Spec: *schedule.Spec.BackupTemplate.DeepCopy(),
+	}
+	err = controllerutil.SetControllerReference(schedule, backup, r.Scheme)
+	if err != nil {
+		return err
+	}
+
+	r.Log.Info("creating scheduled backup", "name", schedule.Name, "namespace", schedule.Namespace, "backup", backup.Name)
+	err = r.Client.Create(ctx, backup)
+	if errors.IsAlreadyExists(err) {
+		r.Log.V(1).Info("scheduled backup already exists", "name", schedule.Name, "namespace", schedule.Namespace, "backup", backup.Name)
+		return nil
+	}
+	return err
+}
+
+// triggerAsync starts the given run in the background, unless the previous run is still active.
+func (r *ScheduleReconciler) triggerAsync(ctx context.Context, schedule *kopiav1alpha1.Schedule, name string, run RunFunc) error {
+	if run == nil {
+		return fmt.Errorf("%s is not supported", name)
+	}
+
+	var repository *k8s.Repository
+	if ref := schedule.Spec.BackupTemplate.RepositoryRef; ref != nil {
+		var err error
+		repository, err = k8s.ResolveRepository(ctx, r.Client, client.ObjectKey{Namespace: schedule.Namespace, Name: ref.Name})
+		if err != nil {
+			return err
+		}
+	}
+
+	key := fmt.Sprintf("%s/%s/%s", schedule.Namespace, schedule.Name, name)
+
+	r.activeMutex.Lock()
+	defer r.activeMutex.Unlock()
+	if r.active[key] {
+		r.Log.Info("previous run is still active, skipping", "name", schedule.Name, "namespace", schedule.Namespace, "run", name)
+		return nil
+	}
+	r.active[key] = true
+
+	log := r.Log.WithValues("name", schedule.Name, "namespace", schedule.Namespace, "run", name)
+	r.runs.Add(1)
+	go func() {
+		defer r.runs.Done()
+		defer func() {
+			r.activeMutex.Lock()
+			defer r.activeMutex.Unlock()
+			delete(r.active, key)
+		}()
+
+		log.Info("starting scheduled run")
+		// The run outlives the reconcile, but not the manager.
+		err := run(r.runCtx, repository)
+		if err != nil {
+			log.Error(err, "scheduled run failed")
+			return
+		}
+		log.Info("scheduled run finished")
+	}()
+	return nil
+}
+
+func (r *ScheduleReconciler) listBackups(ctx context.Context, schedule *kopiav1alpha1.Schedule) ([]kopiav1alpha1.Backup, error) {
+	list := &kopiav1alpha1.BackupList{}
+	err := r.Client.List(ctx, list, client.InNamespace(schedule.Namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []kopiav1alpha1.Backup{}
+	for _, backup := range list.Items {
+		if metav1.IsControlledBy(&backup, schedule) {
+			backups = append(backups, backup)
+		}
+	}
+	return backups, nil
+}
+
+// cleanupBackups deletes the oldest finished Backups of the schedule that exceed the history limit.
+func (r *ScheduleReconciler) cleanupBackups(ctx context.Context, schedule *kopiav1alpha1.Schedule) error {
+	limit := defaultBackupHistoryLimit
+	if schedule.Spec.BackupHistoryLimit != nil {
+		limit = *schedule.Spec.BackupHistoryLimit
+	}
+
+	backups, err := r.listBackups(ctx, schedule)
+	if err != nil {
+		return err
+	}
+
+	finished := []kopiav1alpha1.Backup{}
+	for _, backup := range backups {
+		if backup.Status.FinishTime != nil {
+			finished = append(finished, backup)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Status.FinishTime.Before(finished[j].Status.FinishTime)
+	})
+
+	for i := 0; i < len(finished)-limit; i++ {
+		r.Log.V(1).Info("deleting old backup", "name", schedule.Name, "namespace", schedule.Namespace, "backup", finished[i].Name)
+		err := r.Client.Delete(ctx, &finished[i])
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopRuns cancels the maintenance and check runs once the manager stops and waits for them to return.
+func (r *ScheduleReconciler) stopRuns(ctx context.Context) error {
+	<-ctx.Done()
+	r.cancelRuns()
+	r.runs.Wait()
+	return nil
+}
+
+// SetupWithManager configures the reconciler.
+func (r *ScheduleReconciler) SetupWithManager(mgr ctrl.Manager, l logr.Logger, uuid string) error {
+	r.Client = mgr.GetClient()
+	r.Scheme = mgr.GetScheme()
+	r.Log = l
+	r.active = map[string]bool{}
+	r.runCtx, r.cancelRuns = context.WithCancel(context.Background())
+	err := mgr.Add(manager.RunnableFunc(r.stopRuns))
+	if err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kopiav1alpha1.Schedule{}).
+		Owns(&kopiav1alpha1.Backup{}).
+		Complete(r)
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kopiav1alpha1 "git.earthnet.ch/simon.beck/kopia-k8s/api/v1alpha1"
+	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestScheduleInvalidCron(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kopiav1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	schedule := &kopiav1alpha1.Schedule{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "schedule", Generation: 1},
+		Spec:       kopiav1alpha1.ScheduleSpec{Backup: "0 2 * * *", Check: "every night"},
+	}
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(schedule).Build()
+	r := &ScheduleReconciler{Client: c, Log: logr.Discard(), Scheme: scheme, active: map[string]bool{}}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(schedule)}
+
+	result, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requeue || result.RequeueAfter != 0 {
+		t.Errorf("got result %+v for an invalid cron, want no requeue", result)
+	}
+	current := &kopiav1alpha1.Schedule{}
+	if err := c.Get(ctx, req.NamespacedName, current); err != nil {
+		t.Fatal(err)
+	}
+	condition := meta.FindStatusCondition(current.Status.Conditions, kopiav1alpha1.ConditionScheduled)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != kopiav1alpha1.ReasonInvalidCron {
+		t.Fatalf("got condition %+v, want reason %s", condition, kopiav1alpha1.ReasonInvalidCron)
+	}
+	if current.Status.Backup.NextTime != nil {
+		t.Error("the backup of an invalid schedule got scheduled")
+	}
+
+	// Nothing changes until the spec does.
+	result, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requeue || result.RequeueAfter != 0 {
+		t.Errorf("got result %+v for an unchanged invalid spec, want no requeue", result)
+	}
+
+	current.Spec.Check = "0 4 * * 0"
+	current.Generation = 2
+	if err := c.Update(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+	result, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter <= 0 {
+		t.Errorf("got result %+v for a fixed spec, want a requeue for the next run", result)
+	}
+	if err := c.Get(ctx, req.NamespacedName, current); err != nil {
+		t.Fatal(err)
+	}
+	condition = meta.FindStatusCondition(current.Status.Conditions, kopiav1alpha1.ConditionScheduled)
+	if condition == nil || condition.Status != metav1.ConditionTrue {
+		t.Errorf("got condition %+v, want true", condition)
+	}
+	if current.Status.Backup.NextTime == nil || current.Status.Check.NextTime == nil {
+		t.Errorf("got status %+v, want the next backup and check to be scheduled", current.Status)
+	}
+}
+
+func TestScheduleRunsStopWithManager(t *testing.T) {
+	r := &ScheduleReconciler{Log: logr.Discard(), active: map[string]bool{}}
+	r.runCtx, r.cancelRuns = context.WithCancel(context.Background())
+
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	run := func(ctx context.Context, _ *k8s.Repository) error {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	}
+	schedule := &kopiav1alpha1.Schedule{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "schedule"}}
+	if err := r.triggerAsync(context.Background(), schedule, "check", run); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	managerCtx, stopManager := context.WithCancel(context.Background())
+	stopManager()
+	if err := r.stopRuns(managerCtx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-canceled:
+	default:
+		t.Error("the run wasn't canceled when the manager stopped")
+	}
+}
@@ -0,0 +1,197 @@
+package k8s
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Filter restricts which pods and PVCs are considered for a backup.
+type Filter struct {
+	// IncludeNamespaces contains glob patterns, only namespaces that match one of them are selected.
+	// Empty selects all namespaces.
+	IncludeNamespaces []string
+	// ExcludeNamespaces contains glob patterns, namespaces that match one of them are never selected.
+	ExcludeNamespaces []string
+	// NamespaceSelector selects the namespaces by their labels.
+	// Nil selects all namespaces.
+	NamespaceSelector labels.Selector
+	// PVCSelector selects the PVCs by their labels.
+	// Nil selects all PVCs.
+	PVCSelector labels.Selector
+	// IncludeStorageClasses contains glob patterns, only PVCs whose storage class matches one of them are selected.
+	// Empty selects all storage classes.
+	IncludeStorageClasses []string
+	// ExcludeStorageClasses contains glob patterns, PVCs whose storage class matches one of them are never selected.
+	ExcludeStorageClasses []string
+	// ExcludeAccessModes skips PVCs that have one of the access modes.
+	ExcludeAccessModes []v1.PersistentVolumeAccessMode
+	// BackupAnnotation is the annotation on namespaces, pods and PVCs that opts them in or out of backups with "true" or "false".
+	BackupAnnotation string
+	// OptIn only selects PVCs that are opted in, either on the PVC itself or on the pod that mounts it.
+	OptIn bool
+}
+
+// accessModes maps the short names of the access modes, as shown by kubectl, to the access modes.
+var accessModes = map[string]v1.PersistentVolumeAccessMode{
+	"RWO":  v1.ReadWriteOnce,
+	"ROX":  v1.ReadOnlyMany,
+	"RWX":  v1.ReadWriteMany,
+	"RWOP": v1.ReadWriteOncePod,
+}
+
+// FilterFromFlags adds the restrictions given by the flags to the base filter, e.g. the one of a Backup resource.
+// The selectors of both have to match, the namespace patterns are taken from the flags.
+func FilterFromFlags(cliCtx *cli.Context, base Filter) (Filter, error) {
+	filter := base
+	filter.IncludeNamespaces = cliCtx.StringSlice("include-namespace")
+	filter.ExcludeNamespaces = cliCtx.StringSlice("exclude-namespace")
+	filter.IncludeStorageClasses = cliCtx.StringSlice("include-storage-class")
+	filter.ExcludeStorageClasses = cliCtx.StringSlice("exclude-storage-class")
+	filter.BackupAnnotation = cliCtx.String("backup-annotation")
+	filter.OptIn = cliCtx.Bool("opt-in")
+
+	patterns := append(append([]string{}, filter.IncludeNamespaces...), filter.ExcludeNamespaces...)
+	patterns = append(append(patterns, filter.IncludeStorageClasses...), filter.ExcludeStorageClasses...)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return filter, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	for _, name := range cliCtx.StringSlice("exclude-access-mode") {
+		mode, ok := accessModes[strings.ToUpper(name)]
+		if !ok {
+			mode = v1.PersistentVolumeAccessMode(name)
+		}
+		switch mode {
+		case v1.ReadWriteOnce, v1.ReadOnlyMany, v1.ReadWriteMany, v1.ReadWriteOncePod:
+			filter.ExcludeAccessModes = append(filter.ExcludeAccessModes, mode)
+		default:
+			return filter, fmt.Errorf("unknown access mode %q", name)
+		}
+	}
+
+	if cliCtx.String("namespace-selector") != "" {
+		selector, err := labels.Parse(cliCtx.String("namespace-selector"))
+		if err != nil {
+			return filter, fmt.Errorf("invalid namespace selector: %w", err)
+		}
+		filter.NamespaceSelector = andSelectors(filter.NamespaceSelector, selector)
+	}
+	if cliCtx.String("pvc-selector") != "" {
+		selector, err := labels.Parse(cliCtx.String("pvc-selector"))
+		if err != nil {
+			return filter, fmt.Errorf("invalid pvc selector: %w", err)
+		}
+		filter.PVCSelector = andSelectors(filter.PVCSelector, selector)
+	}
+	return filter, nil
+}
+
+// andSelectors returns a selector that only matches if both given selectors match.
+func andSelectors(a, b labels.Selector) labels.Selector {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	requirements, _ := b.Requirements()
+	return a.Add(requirements...)
+}
+
+// namespaceMatcher decides if a namespace matches the filter.
+type namespaceMatcher struct {
+	// namespaces contains the matching namespaces, nil matches all.
+	namespaces map[string]bool
+}
+
+func (n namespaceMatcher) matches(namespace string) bool {
+	return n.namespaces == nil || n.namespaces[namespace]
+}
+
+func (f Filter) namespaceMatcher(cliCtx *cli.Context, k8sClient client.Client) (namespaceMatcher, error) {
+	if f.NamespaceSelector == nil && len(f.IncludeNamespaces) == 0 && len(f.ExcludeNamespaces) == 0 && f.BackupAnnotation == "" {
+		return namespaceMatcher{}, nil
+	}
+
+	namespaces := &v1.NamespaceList{}
+	err := k8sClient.List(cliCtx.Context, namespaces, &client.ListOptions{LabelSelector: f.NamespaceSelector})
+	if err != nil {
+		return namespaceMatcher{}, fmt.Errorf("cannot list namespaces: %w", err)
+	}
+
+	matcher := namespaceMatcher{namespaces: map[string]bool{}}
+	for _, namespace := range namespaces.Items {
+		if f.optedOut(namespace.Annotations) {
+			continue
+		}
+		if len(f.IncludeNamespaces) > 0 && !matchesAny(f.IncludeNamespaces, namespace.Name) {
+			continue
+		}
+		if matchesAny(f.ExcludeNamespaces, namespace.Name) {
+			continue
+		}
+		matcher.namespaces[namespace.Name] = true
+	}
+	return matcher, nil
+}
+
+// matchesAny returns true if the name matches one of the glob patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPVC decides if the PVC gets backed up.
+// The pod is the one that mounts the PVC, it's nil for unmounted PVCs.
+func (f Filter) matchesPVC(pvc *v1.PersistentVolumeClaim, pod *v1.Pod) bool {
+	if f.PVCSelector != nil && !f.PVCSelector.Matches(labels.Set(pvc.Labels)) {
+		return false
+	}
+
+	if f.optedOut(pvc.Annotations) || (pod != nil && f.optedOut(pod.Annotations)) {
+		return false
+	}
+	if f.OptIn && !f.optedIn(pvc.Annotations) && (pod == nil || !f.optedIn(pod.Annotations)) {
+		return false
+	}
+
+	storageClass := ""
+	if pvc.Spec.StorageClassName != nil {
+		storageClass = *pvc.Spec.StorageClassName
+	}
+	if len(f.IncludeStorageClasses) > 0 && !matchesAny(f.IncludeStorageClasses, storageClass) {
+		return false
+	}
+	if matchesAny(f.ExcludeStorageClasses, storageClass) {
+		return false
+	}
+
+	for _, mode := range pvc.Spec.AccessModes {
+		for _, excluded := range f.ExcludeAccessModes {
+			if mode == excluded {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f Filter) optedOut(annotations map[string]string) bool {
+	return f.BackupAnnotation != "" && annotations[f.BackupAnnotation] == "false"
+}
+
+func (f Filter) optedIn(annotations map[string]string) bool {
+	return f.BackupAnnotation != "" && annotations[f.BackupAnnotation] == "true"
+}
@@ -0,0 +1,231 @@
+package k8s
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const testAnnotation = "kopia.earthnet.ch/backup"
+
+func TestMatchesAny(t *testing.T) {
+	tests := map[string]struct {
+		patterns []string
+		name     string
+		want     bool
+	}{
+		"no patterns":     {name: "default"},
+		"exact match":     {patterns: []string{"kube-system", "default"}, name: "default", want: true},
+		"glob match":      {patterns: []string{"team-*"}, name: "team-a", want: true},
+		"glob mismatch":   {patterns: []string{"team-*"}, name: "default"},
+		"invalid pattern": {patterns: []string{"["}, name: "["},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := matchesAny(tt.patterns, tt.name); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAndSelectors(t *testing.T) {
+	a := labels.SelectorFromSet(labels.Set{"app": "db"})
+	b := labels.SelectorFromSet(labels.Set{"tier": "data"})
+
+	if andSelectors(nil, nil) != nil {
+		t.Error("expected nil for two nil selectors")
+	}
+	if got := andSelectors(nil, b); got.String() != b.String() {
+		t.Errorf("got %q, want %q", got, b)
+	}
+	if got := andSelectors(a, nil); got.String() != a.String() {
+		t.Errorf("got %q, want %q", got, a)
+	}
+
+	both := andSelectors(a, b)
+	if !both.Matches(labels.Set{"app": "db", "tier": "data"}) {
+		t.Error("expected a match when both selectors match")
+	}
+	if both.Matches(labels.Set{"app": "db"}) {
+		t.Error("expected no match when only one selector matches")
+	}
+}
+
+func TestMatchesPVC(t *testing.T) {
+	newPVC := func(storageClass string, annotations, pvcLabels map[string]string, modes ...v1.PersistentVolumeAccessMode) *v1.PersistentVolumeClaim {
+		pvc := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Annotations: annotations, Labels: pvcLabels},
+			Spec:       v1.PersistentVolumeClaimSpec{AccessModes: modes},
+		}
+		if storageClass != "" {
+			pvc.Spec.StorageClassName = &storageClass
+		}
+		return pvc
+	}
+	podWith := func(annotations map[string]string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: annotations}}
+	}
+	optIn := map[string]string{testAnnotation: "true"}
+	optOut := map[string]string{testAnnotation: "false"}
+
+	tests := map[string]struct {
+		filter Filter
+		pvc    *v1.PersistentVolumeClaim
+		pod    *v1.Pod
+		want   bool
+	}{
+		"empty filter": {
+			pvc:  newPVC("", nil, nil),
+			want: true,
+		},
+		"selector matches": {
+			filter: Filter{PVCSelector: labels.SelectorFromSet(labels.Set{"backup": "yes"})},
+			pvc:    newPVC("", nil, map[string]string{"backup": "yes"}),
+			want:   true,
+		},
+		"selector doesn't match": {
+			filter: Filter{PVCSelector: labels.SelectorFromSet(labels.Set{"backup": "yes"})},
+			pvc:    newPVC("", nil, nil),
+		},
+		"pvc opted out": {
+			filter: Filter{BackupAnnotation: testAnnotation},
+			pvc:    newPVC("", optOut, nil),
+		},
+		"pod opted out": {
+			filter: Filter{BackupAnnotation: testAnnotation},
+			pvc:    newPVC("", nil, nil),
+			pod:    podWith(optOut),
+		},
+		"opt-in without annotation": {
+			filter: Filter{BackupAnnotation: testAnnotation, OptIn: true},
+			pvc:    newPVC("", nil, nil),
+		},
+		"opt-in on pvc": {
+			filter: Filter{BackupAnnotation: testAnnotation, OptIn: true},
+			pvc:    newPVC("", optIn, nil),
+			want:   true,
+		},
+		"opt-in on pod": {
+			filter: Filter{BackupAnnotation: testAnnotation, OptIn: true},
+			pvc:    newPVC("", nil, nil),
+			pod:    podWith(optIn),
+			want:   true,
+		},
+		"opt-in on pod but pvc opted out": {
+			filter: Filter{BackupAnnotation: testAnnotation, OptIn: true},
+			pvc:    newPVC("", optOut, nil),
+			pod:    podWith(optIn),
+		},
+		"included storage class": {
+			filter: Filter{IncludeStorageClasses: []string{"ssd-*"}},
+			pvc:    newPVC("ssd-fast", nil, nil),
+			want:   true,
+		},
+		"storage class not included": {
+			filter: Filter{IncludeStorageClasses: []string{"ssd-*"}},
+			pvc:    newPVC("hdd", nil, nil),
+		},
+		"no storage class not included": {
+			filter: Filter{IncludeStorageClasses: []string{"ssd-*"}},
+			pvc:    newPVC("", nil, nil),
+		},
+		"excluded storage class": {
+			filter: Filter{ExcludeStorageClasses: []string{"local-*"}},
+			pvc:    newPVC("local-path", nil, nil),
+		},
+		"excluded access mode": {
+			filter: Filter{ExcludeAccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}},
+			pvc:    newPVC("", nil, nil, v1.ReadWriteOnce, v1.ReadWriteMany),
+		},
+		"other access mode": {
+			filter: Filter{ExcludeAccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}},
+			pvc:    newPVC("", nil, nil, v1.ReadWriteOnce),
+			want:   true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.filter.matchesPVC(tt.pvc, tt.pod); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterFromFlags(t *testing.T) {
+	tests := map[string]struct {
+		args    []string
+		wantErr bool
+		check   func(t *testing.T, filter Filter)
+	}{
+		"access modes": {
+			args: []string{"--exclude-access-mode", "rwx", "--exclude-access-mode", "ReadOnlyMany"},
+			check: func(t *testing.T, filter Filter) {
+				want := []v1.PersistentVolumeAccessMode{v1.ReadWriteMany, v1.ReadOnlyMany}
+				if len(filter.ExcludeAccessModes) != 2 || filter.ExcludeAccessModes[0] != want[0] || filter.ExcludeAccessModes[1] != want[1] {
+					t.Errorf("got access modes %v, want %v", filter.ExcludeAccessModes, want)
+				}
+			},
+		},
+		"unknown access mode": {
+			args:    []string{"--exclude-access-mode", "RWM"},
+			wantErr: true,
+		},
+		"invalid pattern": {
+			args:    []string{"--include-namespace", "team-["},
+			wantErr: true,
+		},
+		"invalid selector": {
+			args:    []string{"--pvc-selector", "app in ("},
+			wantErr: true,
+		},
+		"selectors are combined with the base": {
+			args: []string{"--pvc-selector", "tier=data"},
+			check: func(t *testing.T, filter Filter) {
+				if !filter.PVCSelector.Matches(labels.Set{"app": "db", "tier": "data"}) {
+					t.Error("expected both selectors to match")
+				}
+				if filter.PVCSelector.Matches(labels.Set{"tier": "data"}) {
+					t.Error("expected the base selector to be kept")
+				}
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			flags := flag.NewFlagSet(name, flag.ContinueOnError)
+			for _, f := range []cli.Flag{
+				&cli.StringSliceFlag{Name: "include-namespace"},
+				&cli.StringSliceFlag{Name: "exclude-namespace"},
+				&cli.StringSliceFlag{Name: "include-storage-class"},
+				&cli.StringSliceFlag{Name: "exclude-storage-class"},
+				&cli.StringSliceFlag{Name: "exclude-access-mode"},
+				&cli.StringFlag{Name: "namespace-selector"},
+				&cli.StringFlag{Name: "pvc-selector"},
+				&cli.StringFlag{Name: "backup-annotation"},
+				&cli.BoolFlag{Name: "opt-in"},
+			} {
+				if err := f.Apply(flags); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			base := Filter{PVCSelector: labels.SelectorFromSet(labels.Set{"app": "db"})}
+			filter, err := FilterFromFlags(cli.NewContext(cli.NewApp(), flags, nil), base)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, filter)
+			}
+		})
+	}
+}
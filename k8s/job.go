@@ -1,21 +1,97 @@
 package k8s
 
 import (
-	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
 	"path"
 	"strings"
+	"sync"
 
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
 	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
 	"github.com/urfave/cli/v2"
 	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// FinishedJobChannel gets notified when a jobb finishes.
-var FinishedJobChannel chan bool = make(chan bool)
+// finishedJobSubscribers contains the channel of the JobRunner that waits for a job.
+// Several runs can be active at the same time, e.g. a backup and a verification in `operator run`,
+// so each of them only gets notified about its own jobs.
+var finishedJobSubscribers = struct {
+	sync.Mutex
+	channels map[client.ObjectKey]chan FinishedJob
+}{channels: map[client.ObjectKey]chan FinishedJob{}}
+
+// NotifyFinishedJob sends the job to the JobRunner that created it.
+// It returns false if no one waits for the job, e.g. because it was created by a previous run.
+func NotifyFinishedJob(job FinishedJob) bool {
+	key := client.ObjectKey{Namespace: job.Namespace, Name: job.Name}
+	finishedJobSubscribers.Lock()
+	channel, ok := finishedJobSubscribers.channels[key]
+	delete(finishedJobSubscribers.channels, key)
+	finishedJobSubscribers.Unlock()
+	if !ok {
+		return false
+	}
+	// Don't block the reconciler until the JobRunner is ready to receive.
+	go func() { channel <- job }()
+	return true
+}
+
+// subscribe routes the notification about the job to this JobRunner.
+func (j *JobRunner) subscribe(job *batchv1.Job) {
+	if j.finished == nil {
+		j.finished = make(chan FinishedJob)
+	}
+	finishedJobSubscribers.Lock()
+	defer finishedJobSubscribers.Unlock()
+	finishedJobSubscribers.channels[client.ObjectKeyFromObject(job)] = j.finished
+}
+
+// unsubscribe stops routing the notification about the job to this JobRunner.
+func (j *JobRunner) unsubscribe(job *batchv1.Job) {
+	finishedJobSubscribers.Lock()
+	defer finishedJobSubscribers.Unlock()
+	delete(finishedJobSubscribers.channels, client.ObjectKeyFromObject(job))
+}
+
+// JobStatus describes how a watched job ended.
+type JobStatus string
+
+const (
+	// JobSucceeded is sent if the job finished successfully.
+	JobSucceeded JobStatus = "succeeded"
+	// JobFailed is sent if the job failed.
+	JobFailed JobStatus = "failed"
+	// JobSkipped is sent if the job's pod was pending for too long.
+	JobSkipped JobStatus = "skipped"
+)
+
+// FinishedJob is sent to the JobRunner of every job that ended.
+type FinishedJob struct {
+	Name      string
+	Namespace string
+	PVC       string
+	Status    JobStatus
+	// Result is only set for successful backup jobs.
+	Result *kopia.BackupResult
+	// Message is the termination message of the job, e.g. the report of a verify job.
+	Message string
+}
+
+// BackupOptions define a single backup run.
+type BackupOptions struct {
+	// RunID is used to generate unique job names.
+	RunID string
+	// Filter restricts the PVCs that get backed up.
+	Filter Filter
+	// Concurrency overrides the concurrency flag if set.
+	Concurrency int
+	// Repository overrides the repository configured with the flags if set.
+	Repository *Repository
+}
 
 // JobRunner contains all necessary information to run the backup jobs.
 type JobRunner struct {
@@ -23,6 +99,26 @@ type JobRunner struct {
 	K8sClient   client.Client
 	Concurrency int
 	PvcList     *BackupPVCList
+	// Retention is the retention policy for all PVCs that don't override it with an annotation.
+	Retention kopia.RetentionPolicy
+	// Finished contains all jobs that ended during RunAndWatchBackupJobs.
+	Finished []FinishedJob
+	// RunID is used to generate unique job names for each run.
+	// It defaults to the uuid flag.
+	RunID string
+	// Repository is passed to the jobs.
+	Repository *Repository
+	// PostBackupPods are the pods whose post-backup command runs once all their backup jobs have ended.
+	PostBackupPods []v1.Pod
+	// PostBackupFailures counts the post-backup commands that failed.
+	PostBackupFailures int
+
+	postBackup *postBackupTracker
+	// finished receives the jobs of this JobRunner that ended.
+	finished chan FinishedJob
+	// credentialsSecrets contains the namespaces the credentials secret of the run was created in
+	// and the jobs that use it there.
+	credentialsSecrets map[string][]client.ObjectKey
 }
 
 const (
@@ -30,89 +126,229 @@ const (
 	JobLabel = "kopia.earthnet.ch"
 	//ContainerName defines the name of the kopia container within the pod
 	ContainerName = "kopia-backup"
+	// PVCAnnotation contains the name of the PVC a job operates on
+	PVCAnnotation = "kopia.earthnet.ch/pvc"
+	// JobTypeAnnotation defines what a job does, e.g. JobTypeBackup
+	JobTypeAnnotation = "kopia.earthnet.ch/type"
+	// JobTypeBackup marks backup jobs
+	JobTypeBackup = "backup"
+	// JobTypeRestore marks restore jobs
+	JobTypeRestore = "restore"
+	// JobTypeVerify marks verify jobs
+	JobTypeVerify = "verify"
 )
 
 // RunAndWatchBackupJobs will start all the jobs for the given PVC list.
-// It will block until all the jobs have either finished or failed.
+// It will block until all the jobs have either finished or failed, even if starting a job failed.
+// The post-backup commands run as soon as all jobs of their pod have ended, those of pods without jobs right away.
+// Afterwards RunPostBackupCommands has to be called for the remaining ones, even if it returned an error.
 func (j *JobRunner) RunAndWatchBackupJobs() error {
 
 	log := logger.AppLogger(j.CliCtx.Context).WithName("BackupAndWatch")
 
-	mountedJobCount := 0
+	j.trackPostBackupPods()
+	defer j.deleteCredentialsSecrets()
+	j.runPostBackupCommandsWithoutJobs()
+
+	jobCount := 0
+	// abort waits for the jobs that were already started, so the post-backup commands don't run
+	// and the credentials don't disappear while they are still running.
+	abort := func(err error) error {
+		log.Error(err, "aborting backup, waiting for the running jobs", "jobs", jobCount)
+		for jobCount > 0 {
+			j.waitForFinishedJob()
+			jobCount--
+		}
+		return err
+	}
 
 	for _, pvc := range j.PvcList.MountedPVCs {
 		// TODO: this has some slight race condition.
 		// It's possible that it spawns one job too many if two jobs finish
 		// at exactly the same time.
-		for mountedJobCount >= j.Concurrency {
-			<-FinishedJobChannel
-			mountedJobCount--
+		for jobCount >= j.Concurrency {
+			j.waitForFinishedJob()
+			jobCount--
 		}
 		log.Info("starting backup", "pvcname", pvc.PVC.Name, "podname", pvc.Pod.Name)
 		createServiceAccount(*j.CliCtx, j.K8sClient, pvc.Pod.Namespace)
 		job := j.newBackupJob(pvc.PVC, pvc.Pod)
-		err := j.K8sClient.Create(j.CliCtx.Context, job)
-		if err != nil && !errors.IsAlreadyExists(err) {
-			return err
+		err := j.createJob(job)
+		if err != nil {
+			return abort(err)
+		}
+		jobCount++
+	}
+
+	for i := range j.PvcList.UnmountedPVCs.Items {
+		pvc := &j.PvcList.UnmountedPVCs.Items[i]
+		if !j.shouldBackupUnmounted(pvc) {
+			log.V(1).Info("skipping unmounted pvc", "pvcname", pvc.Name, "namespace", pvc.Namespace)
+			continue
+		}
+
+		affinity, err := volumeAffinity(j.CliCtx, j.K8sClient, pvc)
+		if err != nil {
+			return abort(err)
+		}
+
+		for jobCount >= j.Concurrency {
+			j.waitForFinishedJob()
+			jobCount--
+		}
+		log.Info("starting backup of unmounted pvc", "pvcname", pvc.Name, "namespace", pvc.Namespace)
+		createServiceAccount(*j.CliCtx, j.K8sClient, pvc.Namespace)
+		job := j.newUnmountedBackupJob(pvc, affinity)
+		err = j.createJob(job)
+		if err != nil {
+			return abort(err)
 		}
-		mountedJobCount++
+		jobCount++
 	}
 
-	for mountedJobCount > 0 {
-		<-FinishedJobChannel
-		mountedJobCount--
+	for jobCount > 0 {
+		j.waitForFinishedJob()
+		jobCount--
 	}
 
 	return nil
 }
 
-func (j *JobRunner) generateJobName(podname, pvcname string) string {
-	seed := strings.Split(j.CliCtx.String("uuid"), "-")[0]
-	name := fmt.Sprintf("kopia-%s-%s-%s", seed, podname, pvcname)
-	if len(name) > 63 {
-		name = name[:63]
+func (j *JobRunner) waitForFinishedJob() {
+	finished := <-j.finished
+	j.Finished = append(j.Finished, finished)
+	j.jobEnded(finished)
+}
+
+// CountFinished returns how many of the finished jobs ended with the given status.
+func (j *JobRunner) CountFinished(status JobStatus) int {
+	count := 0
+	for _, job := range j.Finished {
+		if job.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// shouldBackupUnmounted returns false if the PVC can't be mounted or if it opted out of unmounted backups.
+func (j *JobRunner) shouldBackupUnmounted(pvc *v1.PersistentVolumeClaim) bool {
+	if pvc.Status.Phase != v1.ClaimBound {
+		return false
+	}
+	return pvc.Annotations[j.CliCtx.String("backup-unmounted-annotation")] != "false"
+}
+
+// generateJobName returns a name for an object of the run that is unique for the given parts.
+// The parts are joined with "-", which is ambiguous and gets truncated to fit the 63 characters of a name,
+// so a short hash of the run and the parts is appended.
+func (j *JobRunner) generateJobName(parts ...string) string {
+	runID := j.RunID
+	if runID == "" {
+		runID = j.CliCtx.String("uuid")
+	}
+	seed := strings.Split(runID, "-")[0]
+	sum := sha256.Sum256([]byte(strings.Join(append([]string{runID}, parts...), "/")))
+	suffix := "-" + hex.EncodeToString(sum[:])[:6]
+
+	name := strings.Join(append([]string{"kopia", seed}, parts...), "-")
+	if len(name) > 63-len(suffix) {
+		name = name[:63-len(suffix)]
 	}
 	// Names that end with "-" are invalid for k8s.
 	// If that's the case we shorten it by one until that's not the case anymore.
 	for strings.HasSuffix(name, "-") {
 		name = name[:len(name)-1]
 	}
-	return name
+	return name + suffix
 }
 
-func (j *JobRunner) getJobEnv() []v1.EnvVar {
-	return []v1.EnvVar{
-		{
-			Name:  "AWS_ACCESS_KEY_ID",
-			Value: j.CliCtx.String("access-key-id"),
-		},
-		{
-			Name:  "AWS_SECRET_ACCESS_KEY",
-			Value: j.CliCtx.String("secret-access-key"),
-		},
-		{
-			Name:  "KK_ENCRYPTION_PASSWORD",
-			Value: j.CliCtx.String("encryption-password"),
+func (j *JobRunner) getJobEnv(jobType string) []v1.EnvVar {
+	env := j.Repository.JobEnv(j.credentialsSecretName())
+	// Only backups may create the repository, restoring from an empty one doesn't make sense.
+	if jobType == JobTypeBackup && j.CliCtx.Bool("create-if-missing") {
+		env = append(env, v1.EnvVar{Name: "KK_CREATE_IF_MISSING", Value: "true"})
+	}
+	return env
+}
+
+func (j JobRunner) newBackupJob(pvc *v1.PersistentVolumeClaim, pod *v1.Pod) *batchv1.Job {
+	return j.newJob(j.generateJobName(pod.Name, pvc.Name), JobTypeBackup, pvc, podAffinity(pod), j.backupArgs(pvc))
+}
+
+// newUnmountedBackupJob returns a backup job for a PVC that isn't mounted by any pod.
+func (j JobRunner) newUnmountedBackupJob(pvc *v1.PersistentVolumeClaim, affinity *v1.Affinity) *batchv1.Job {
+	return j.newJob(j.generateJobName(pvc.Name), JobTypeBackup, pvc, affinity, j.backupArgs(pvc))
+}
+
+func (j JobRunner) backupArgs(pvc *v1.PersistentVolumeClaim) []string {
+	args := append([]string{"kopia"}, j.retentionPolicy(pvc).Flags()...)
+	return append(args,
+		"backup",
+		"--path",
+		path.Join("/data", pvc.Name),
+		"--hostname",
+		pvc.Namespace,
+		"--result-file",
+		"/dev/termination-log",
+	)
+}
+
+// podAffinity returns an affinity that schedules a pod on the same node as the given pod.
+func podAffinity(pod *v1.Pod) *v1.Affinity {
+	return &v1.Affinity{
+		PodAffinity: &v1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+				{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: pod.Labels,
+					},
+					TopologyKey: "kubernetes.io/hostname",
+				},
+			},
 		},
+	}
+}
+
+// newJob returns a job that runs kopia-k8s with the given args and mounts the PVC under /data/<pvcname>.
+func (j JobRunner) newJob(name, jobType string, pvc *v1.PersistentVolumeClaim, affinity *v1.Affinity, args []string) *batchv1.Job {
+	job := j.newRepositoryJob(name, pvc.Namespace, jobType, args)
+	job.Annotations[PVCAnnotation] = pvc.Name
+
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Affinity = affinity
+	podSpec.Containers[0].VolumeMounts = append([]v1.VolumeMount{
 		{
-			Name:  "KK_BUCKET",
-			Value: j.CliCtx.String("bucket"),
+			Name:      "data",
+			MountPath: path.Join("/data", pvc.Name),
 		},
+	}, podSpec.Containers[0].VolumeMounts...)
+	podSpec.Volumes = append([]v1.Volume{
 		{
-			Name:  "KK_ENDPOINT",
-			Value: j.CliCtx.String("s3-endpoint"),
+			Name: "data",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: pvc.Name,
+				},
+			},
 		},
-	}
+	}, podSpec.Volumes...)
+	return job
 }
 
-func (j JobRunner) newBackupJob(pvc *v1.PersistentVolumeClaim, pod *v1.Pod) *batchv1.Job {
+// newRepositoryJob returns a job that runs kopia-k8s with the given args and only has access to the repository.
+func (j JobRunner) newRepositoryJob(name, namespace, jobType string, args []string) *batchv1.Job {
+	repositoryVolumes, repositoryMounts := j.Repository.JobVolumes(j.credentialsSecretName())
 	return &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      j.generateJobName(pod.Name, pvc.Name),
-			Namespace: pvc.Namespace,
+			Name:      name,
+			Namespace: namespace,
 			Labels: map[string]string{
 				JobLabel: j.CliCtx.String("uuid"),
 			},
+			Annotations: map[string]string{
+				JobTypeAnnotation: jobType,
+			},
 		},
 		Spec: batchv1.JobSpec{
 			Template: v1.PodTemplateSpec{
@@ -123,49 +359,16 @@ func (j JobRunner) newBackupJob(pvc *v1.PersistentVolumeClaim, pod *v1.Pod) *bat
 				},
 				Spec: v1.PodSpec{
 					ServiceAccountName: "kopia-k8s",
-					Affinity: &v1.Affinity{
-						PodAffinity: &v1.PodAffinity{
-							RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
-								{
-									LabelSelector: &metav1.LabelSelector{
-										MatchLabels: pod.Labels,
-									},
-									TopologyKey: "kubernetes.io/hostname",
-								},
-							},
-						},
-					},
 					Containers: []v1.Container{
 						{
-							Name:  ContainerName,
-							Image: "192.168.6.10:5000/kopia-k8s:latest",
-							Args: []string{
-								"kopia",
-								"backup",
-								"--path",
-								path.Join("/data", pvc.Name),
-								"--hostname",
-								pod.Namespace,
-							},
-							Env: j.getJobEnv(),
-							VolumeMounts: []v1.VolumeMount{
-								{
-									Name:      "data",
-									MountPath: path.Join("/data", pvc.Name),
-								},
-							},
-						},
-					},
-					Volumes: []v1.Volume{
-						{
-							Name: "data",
-							VolumeSource: v1.VolumeSource{
-								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
-									ClaimName: pvc.Name,
-								},
-							},
+							Name:         ContainerName,
+							Image:        "192.168.6.10:5000/kopia-k8s:latest",
+							Args:         args,
+							Env:          j.getJobEnv(jobType),
+							VolumeMounts: repositoryMounts,
 						},
 					},
+					Volumes:       repositoryVolumes,
 					RestartPolicy: v1.RestartPolicyOnFailure,
 				},
 			},
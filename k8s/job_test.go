@@ -0,0 +1,45 @@
+package k8s
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateJobName(t *testing.T) {
+	j := &JobRunner{RunID: "1a2b3c4d-0000-0000-0000-000000000000"}
+	long := strings.Repeat("x", 70)
+
+	tests := map[string]struct {
+		a, b []string
+	}{
+		"ambiguous separator": {
+			a: []string{"app", "data-db"},
+			b: []string{"app-data-db"},
+		},
+		"truncated": {
+			a: []string{long, "data"},
+			b: []string{long, "logs"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, b := j.generateJobName(tt.a...), j.generateJobName(tt.b...)
+			if a == b {
+				t.Errorf("got the same name %q for %v and %v", a, tt.a, tt.b)
+			}
+			for _, name := range []string{a, b} {
+				if len(name) > 63 || strings.HasSuffix(name, "-") || !strings.HasPrefix(name, "kopia-1a2b3c4d-") {
+					t.Errorf("invalid name %q", name)
+				}
+			}
+		})
+	}
+
+	if a, b := j.generateJobName("app", "data"), j.generateJobName("app", "data"); a != b {
+		t.Errorf("got different names %q and %q for the same parts", a, b)
+	}
+	other := &JobRunner{RunID: "1a2b3c4d-1111-1111-1111-111111111111"}
+	if a, b := j.generateJobName("app", "data"), other.generateJobName("app", "data"); a == b {
+		t.Errorf("got the same name %q for different runs", a)
+	}
+}
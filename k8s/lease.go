@@ -0,0 +1,197 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
+
+// MaintenanceLease is a lock on a Lease object that ensures only one maintenance runs per repository at a time.
+// While it's held, it gets renewed in the background.
+type MaintenanceLease struct {
+	Client    client.Client
+	Namespace string
+	Name      string
+	// Holder identifies who holds the lease.
+	// It has to be unique even within a pod, as the operator can run several maintenances concurrently.
+	Holder string
+	// Duration is how long the lease is valid without being renewed.
+	// Another holder takes over an expired lease.
+	Duration time.Duration
+
+	stopRenewal context.CancelFunc
+	renewalDone chan struct{}
+}
+
+// MaintenanceLeaseName returns the name of the lease for the given repository.
+func MaintenanceLeaseName(repository *Repository) string {
+	return "kopia-k8s-maintenance-" + repository.ID()
+}
+
+// ID returns a short, stable identifier for the storage location of the repository.
+// Only the location is part of it, so it stays the same if the credentials get rotated.
+func (r *Repository) ID() string {
+	sum := sha256.Sum256([]byte(strings.Join(r.location(), "\x00")))
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// location returns the fields that identify where the repository is stored.
+func (r *Repository) location() []string {
+	switch r.Type {
+	case kopia.StorageFilesystem:
+		return []string{r.Type, r.Filesystem.Path}
+	case kopia.StorageSFTP:
+		return []string{r.Type, r.SFTP.Host, strconv.Itoa(r.SFTP.Port), r.SFTP.Path}
+	case kopia.StorageAzure:
+		return []string{r.Type, r.Azure.StorageDomain, r.Azure.StorageAccount, r.Azure.Container, r.Azure.Prefix}
+	case kopia.StorageGCS:
+		return []string{r.Type, r.GCS.Bucket, r.GCS.Prefix}
+	case kopia.StorageB2:
+		return []string{r.Type, r.B2.Bucket, r.B2.Prefix}
+	case kopia.StorageS3:
+		return []string{r.Type, r.S3.Endpoint, r.S3.Bucket, r.S3.Prefix}
+	}
+	return []string{r.Type}
+}
+
+// TryAcquire takes the lease if it's free, expired or already ours.
+// If someone else holds it, it returns false and the current holder.
+func (l *MaintenanceLease) TryAcquire(ctx context.Context) (bool, string, error) {
+	if l.Duration <= 0 {
+		return false, "", fmt.Errorf("lease duration has to be positive, got %s", l.Duration)
+	}
+
+	lease := &coordinationv1.Lease{}
+	err := l.Client.Get(ctx, client.ObjectKey{Namespace: l.Namespace, Name: l.Name}, lease)
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: l.Namespace,
+				Name:      l.Name,
+			},
+		}
+		l.hold(lease)
+		err = l.Client.Create(ctx, lease)
+		if apierrors.IsAlreadyExists(err) {
+			return false, "", nil
+		}
+		if err != nil {
+			return false, "", err
+		}
+		l.startRenewal(ctx)
+		return true, l.Holder, nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	if holder := l.holder(lease); holder != "" && holder != l.Holder {
+		return false, holder, nil
+	}
+
+	l.hold(lease)
+	err = l.Client.Update(ctx, lease)
+	if apierrors.IsConflict(err) {
+		// Someone else was faster.
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	l.startRenewal(ctx)
+	return true, l.Holder, nil
+}
+
+// Release stops the renewal and frees the lease, so the next maintenance doesn't have to wait until it expires.
+func (l *MaintenanceLease) Release(ctx context.Context) error {
+	if l.stopRenewal != nil {
+		l.stopRenewal()
+		<-l.renewalDone
+	}
+
+	lease := &coordinationv1.Lease{}
+	err := l.Client.Get(ctx, client.ObjectKey{Namespace: l.Namespace, Name: l.Name}, lease)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if l.holder(lease) != l.Holder {
+		return nil
+	}
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.RenewTime = nil
+	return client.IgnoreNotFound(l.Client.Update(ctx, lease))
+}
+
+// holder returns who currently holds the lease, or an empty string if it's free or expired.
+func (l *MaintenanceLease) holder(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil {
+		return ""
+	}
+	duration := l.Duration
+	if lease.Spec.LeaseDurationSeconds != nil {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	if time.Since(lease.Spec.RenewTime.Time) > duration {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// hold sets us as holder of the lease.
+func (l *MaintenanceLease) hold(lease *coordinationv1.Lease) {
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(l.Duration.Seconds())
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.Holder {
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = &l.Holder
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+}
+
+// startRenewal renews the lease until Release is called.
+func (l *MaintenanceLease) startRenewal(ctx context.Context) {
+	log := logger.AppLogger(ctx).WithName("maintenanceLease")
+	ctx, l.stopRenewal = context.WithCancel(ctx)
+	l.renewalDone = make(chan struct{})
+
+	go func() {
+		defer close(l.renewalDone)
+		ticker := time.NewTicker(l.Duration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := l.renew(ctx)
+				if err != nil {
+					log.Error(err, "cannot renew lease", "namespace", l.Namespace, "name", l.Name)
+				}
+			}
+		}
+	}()
+}
+
+func (l *MaintenanceLease) renew(ctx context.Context) error {
+	lease := &coordinationv1.Lease{}
+	err := l.Client.Get(ctx, client.ObjectKey{Namespace: l.Namespace, Name: l.Name}, lease)
+	if err != nil {
+		return err
+	}
+	l.hold(lease)
+	return l.Client.Update(ctx, lease)
+}
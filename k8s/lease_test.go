@@ -0,0 +1,63 @@
+package k8s
+
+import (
+	"testing"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+)
+
+func TestRepositoryID(t *testing.T) {
+	tests := map[string]struct {
+		a, b     Repository
+		wantSame bool
+	}{
+		"s3 credentials rotated": {
+			a:        Repository{Type: kopia.StorageS3, S3: kopia.S3Options{Endpoint: "s3.example.com", Bucket: "backup", AccessKeyID: "old", SecretAccessKey: "old"}},
+			b:        Repository{Type: kopia.StorageS3, S3: kopia.S3Options{Endpoint: "s3.example.com", Bucket: "backup", AccessKeyID: "new", SecretAccessKey: "new"}},
+			wantSame: true,
+		},
+		"s3 other bucket": {
+			a: Repository{Type: kopia.StorageS3, S3: kopia.S3Options{Endpoint: "s3.example.com", Bucket: "backup"}},
+			b: Repository{Type: kopia.StorageS3, S3: kopia.S3Options{Endpoint: "s3.example.com", Bucket: "other"}},
+		},
+		"s3 other prefix": {
+			a: Repository{Type: kopia.StorageS3, S3: kopia.S3Options{Bucket: "backup", Prefix: "a/"}},
+			b: Repository{Type: kopia.StorageS3, S3: kopia.S3Options{Bucket: "backup", Prefix: "b/"}},
+		},
+		"azure key replaced by sas token": {
+			a:        Repository{Type: kopia.StorageAzure, Azure: kopia.AzureOptions{StorageAccount: "account", Container: "backup", StorageKey: "key"}},
+			b:        Repository{Type: kopia.StorageAzure, Azure: kopia.AzureOptions{StorageAccount: "account", Container: "backup", SASToken: "token"}},
+			wantSame: true,
+		},
+		"b2 key rotated": {
+			a:        Repository{Type: kopia.StorageB2, B2: kopia.B2Options{Bucket: "backup", KeyID: "old", Key: "old"}},
+			b:        Repository{Type: kopia.StorageB2, B2: kopia.B2Options{Bucket: "backup", KeyID: "new", Key: "new"}},
+			wantSame: true,
+		},
+		"sftp key file and key data": {
+			a:        Repository{Type: kopia.StorageSFTP, SFTP: kopia.SFTPOptions{Host: "backup.example.com", Port: 22, Path: "/srv/kopia", KeyFile: "/etc/kopia/key"}},
+			b:        Repository{Type: kopia.StorageSFTP, SFTP: kopia.SFTPOptions{Host: "backup.example.com", Port: 22, Path: "/srv/kopia", KeyData: "key"}},
+			wantSame: true,
+		},
+		"gcs credentials file and data": {
+			a:        Repository{Type: kopia.StorageGCS, GCS: kopia.GCSOptions{Bucket: "backup", CredentialsFile: "/etc/kopia/gcs.json"}},
+			b:        Repository{Type: kopia.StorageGCS, GCS: kopia.GCSOptions{Bucket: "backup", CredentialsData: "{}"}},
+			wantSame: true,
+		},
+		"same path on other backend": {
+			a: Repository{Type: kopia.StorageFilesystem, Filesystem: kopia.FilesystemOptions{Path: "/srv/kopia"}},
+			b: Repository{Type: kopia.StorageSFTP, SFTP: kopia.SFTPOptions{Path: "/srv/kopia"}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, b := tt.a.ID(), tt.b.ID()
+			if len(a) != 10 {
+				t.Errorf("got ID %q, want 10 characters", a)
+			}
+			if (a == b) != tt.wantSame {
+				t.Errorf("got IDs %q and %q, want same %v", a, b, tt.wantSame)
+			}
+		})
+	}
+}
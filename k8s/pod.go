@@ -2,6 +2,7 @@ package k8s
 
 import (
 	"fmt"
+	"sort"
 
 	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
 	"github.com/urfave/cli/v2"
@@ -19,8 +20,8 @@ import (
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=pods/exec,verbs=get;list;watch;create;update;patch;delete
 
-// listPodsWithPVCs returns a list with pods that have a PVC mounted.
-func listPodsWithPVCs(cliCtx *cli.Context, k8sClient client.Client) (*v1.PodList, error) {
+// listPodsWithPVCs returns a list with pods in the matching namespaces that have a PVC mounted.
+func listPodsWithPVCs(cliCtx *cli.Context, k8sClient client.Client, namespaces namespaceMatcher) (*v1.PodList, error) {
 	tmp := &v1.PodList{}
 
 	selector, err := createLabelSelector()
@@ -32,6 +33,9 @@ func listPodsWithPVCs(cliCtx *cli.Context, k8sClient client.Client) (*v1.PodList
 
 	pods := &v1.PodList{}
 	for _, pod := range tmp.Items {
+		if !namespaces.matches(pod.Namespace) {
+			continue
+		}
 		for _, volume := range pod.Spec.Volumes {
 			if volume.PersistentVolumeClaim != nil && pod.Status.Phase == v1.PodRunning {
 				pods.Items = append(pods.Items, pod)
@@ -54,36 +58,38 @@ func createLabelSelector() (labels.Selector, error) {
 	return selector, err
 }
 
-func listPodsWithPrebackupAnnotation(cliCtx *cli.Context, k8sClient client.Client) (*v1.PodList, error) {
-	tmp := &v1.PodList{}
-	err := k8sClient.List(cliCtx.Context, tmp)
-	if err != nil {
-		return nil, err
-	}
-
-	annotation := cliCtx.String("pre-backup-annotation")
-
-	pods := &v1.PodList{}
-	for _, pod := range tmp.Items {
-		if _, ok := pod.Annotations[annotation]; ok {
-			pods.Items = append(pods.Items, pod)
+// podsWithAnnotation returns the pods that mount at least one of the PVCs that get backed up and have the given annotation.
+// Pods without such a PVC don't take part in the backup, so their hooks don't run.
+func podsWithAnnotation(pvcList *BackupPVCList, annotation string) []v1.Pod {
+	seen := map[client.ObjectKey]bool{}
+	pods := []v1.Pod{}
+	for _, mounted := range pvcList.MountedPVCs {
+		key := client.ObjectKeyFromObject(mounted.Pod)
+		if _, ok := mounted.Pod.Annotations[annotation]; !ok || seen[key] {
+			continue
 		}
+		seen[key] = true
+		pods = append(pods, *mounted.Pod)
 	}
-	return pods, nil
+	// The PVCs are a map, sort the pods so the commands always run in the same order.
+	sort.Slice(pods, func(i, k int) bool {
+		if pods[i].Namespace != pods[k].Namespace {
+			return pods[i].Namespace < pods[k].Namespace
+		}
+		return pods[i].Name < pods[k].Name
+	})
+	return pods
 }
 
 // ExecutePrebackupCommand rund prebackup commands on the pods before actually starting the backup
-func ExecutePrebackupCommand(cliCtx *cli.Context, k8sClient client.Client) error {
+// Only the pods that mount one of the PVCs in the list are considered.
+func ExecutePrebackupCommand(cliCtx *cli.Context, pvcList *BackupPVCList) error {
 	log := logger.AppLogger(cliCtx.Context).WithName("prebackupExec")
+	annotation := cliCtx.String("pre-backup-annotation")
 
-	pods, err := listPodsWithPrebackupAnnotation(cliCtx, k8sClient)
-	if err != nil {
-		return err
-	}
-
-	for _, pod := range pods.Items {
-		log.Info("running prebackup command", "podname", pod.Name, "command", pod.Annotations[cliCtx.String("pre-backup-annotation")])
-		err := execPod(cliCtx, &pod)
+	for _, pod := range podsWithAnnotation(pvcList, annotation) {
+		log.Info("running prebackup command", "podname", pod.Name, "command", pod.Annotations[annotation])
+		err := execPod(cliCtx, &pod, pod.Annotations[annotation])
 		if err != nil {
 			return err
 		}
@@ -92,6 +98,18 @@ func ExecutePrebackupCommand(cliCtx *cli.Context, k8sClient client.Client) error
 	return nil
 }
 
+// ListPostbackupPods returns the pods that have a post-backup command and mount one of the PVCs in the list.
+func ListPostbackupPods(cliCtx *cli.Context, pvcList *BackupPVCList) []v1.Pod {
+	return podsWithAnnotation(pvcList, cliCtx.String("post-backup-annotation"))
+}
+
+// ExecutePostbackupCommand runs the post-backup command of the pod.
+func ExecutePostbackupCommand(cliCtx *cli.Context, pod *v1.Pod) error {
+	command := pod.Annotations[cliCtx.String("post-backup-annotation")]
+	logger.AppLogger(cliCtx.Context).WithName("postbackupExec").Info("running postbackup command", "podname", pod.Name, "namespace", pod.Namespace, "command", command)
+	return execPod(cliCtx, pod, command)
+}
+
 func getClientConfig() (*rest.Config, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -122,11 +140,12 @@ func getClientConfig() (*rest.Config, error) {
 
 // }
 
-func execPod(cliCtx *cli.Context, pod *v1.Pod) error {
+// execPod runs the command with `sh -c` in the first container of the pod.
+func execPod(cliCtx *cli.Context, pod *v1.Pod, command string) error {
 	cmd := []string{
 		"sh",
 		"-c",
-		pod.Annotations[cliCtx.String("pre-backup-annotation")],
+		command,
 	}
 
 	config, err := getClientConfig()
@@ -174,3 +193,32 @@ func execPod(cliCtx *cli.Context, pod *v1.Pod) error {
 
 	return nil
 }
+
+// findRunningPodForPVC returns a running pod that has the given PVC mounted.
+// If no such pod exists, it returns nil.
+func findRunningPodForPVC(cliCtx *cli.Context, k8sClient client.Client, pvc *v1.PersistentVolumeClaim) (*v1.Pod, error) {
+	pods := &v1.PodList{}
+
+	selector, err := createLabelSelector()
+	if err != nil {
+		return nil, err
+	}
+
+	err = k8sClient.List(cliCtx.Context, pods, client.InNamespace(pvc.Namespace), &client.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list pods in namespace %s: %w", pvc.Namespace, err)
+	}
+
+	for i, pod := range pods.Items {
+		if pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvc.Name {
+				return &pods.Items[i], nil
+			}
+		}
+	}
+
+	return nil, nil
+}
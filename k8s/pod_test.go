@@ -0,0 +1,43 @@
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodsWithAnnotation(t *testing.T) {
+	const annotation = "kopia.earthnet.ch/prebackup"
+	newPod := func(namespace, name string, annotations map[string]string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Annotations: annotations}}
+	}
+	newPVC := func(namespace, name string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	hook := map[string]string{annotation: "sync"}
+	db := newPod("b", "db", hook)
+
+	pvcList := &BackupPVCList{
+		MountedPVCs: map[string]MountedPVC{
+			"data:b":  {Pod: db, PVC: newPVC("b", "data")},
+			"logs:b":  {Pod: db, PVC: newPVC("b", "logs")},
+			"web:a":   {Pod: newPod("a", "web", hook), PVC: newPVC("a", "web")},
+			"cache:a": {Pod: newPod("a", "cache", nil), PVC: newPVC("a", "cache")},
+		},
+		UnmountedPVCs: &v1.PersistentVolumeClaimList{},
+	}
+
+	var names []string
+	for _, pod := range podsWithAnnotation(pvcList, annotation) {
+		names = append(names, pod.Namespace+"/"+pod.Name)
+	}
+	if want := []string{"a/web", "b/db"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got pods %v, want %v", names, want)
+	}
+
+	if pods := podsWithAnnotation(&BackupPVCList{}, annotation); len(pods) != 0 {
+		t.Errorf("got pods %v without PVCs", pods)
+	}
+}
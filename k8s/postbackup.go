@@ -0,0 +1,116 @@
+package k8s
+
+import (
+	"fmt"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// PostBackupFailurePolicyFail fails the run if a post-backup command fails.
+	PostBackupFailurePolicyFail = "fail"
+	// PostBackupFailurePolicyIgnore only logs failed post-backup commands.
+	PostBackupFailurePolicyIgnore = "ignore"
+)
+
+// postBackupTracker keeps track of the backup jobs of the pods with a post-backup command.
+type postBackupTracker struct {
+	// pods contains the pods whose post-backup command hasn't run yet.
+	pods map[client.ObjectKey]*v1.Pod
+	// pendingJobs counts the backup jobs of each pod that haven't ended yet.
+	pendingJobs map[client.ObjectKey]int
+	// jobPods maps the backup jobs to the pod whose PVC they back up.
+	jobPods map[client.ObjectKey]client.ObjectKey
+	// err is the first post-backup command that failed, if the failure policy is PostBackupFailurePolicyFail.
+	err error
+}
+
+// trackPostBackupPods registers the backup jobs of all pods with a post-backup command.
+// It has to be called before the first job is started, so the command of a pod doesn't run before all its jobs have ended.
+func (j *JobRunner) trackPostBackupPods() {
+	if j.postBackup != nil {
+		return
+	}
+	j.postBackup = &postBackupTracker{
+		pods:        map[client.ObjectKey]*v1.Pod{},
+		pendingJobs: map[client.ObjectKey]int{},
+		jobPods:     map[client.ObjectKey]client.ObjectKey{},
+	}
+	for i := range j.PostBackupPods {
+		pod := &j.PostBackupPods[i]
+		j.postBackup.pods[client.ObjectKeyFromObject(pod)] = pod
+	}
+	if j.PvcList == nil {
+		return
+	}
+	for _, mounted := range j.PvcList.MountedPVCs {
+		podKey := client.ObjectKeyFromObject(mounted.Pod)
+		if _, ok := j.postBackup.pods[podKey]; !ok {
+			continue
+		}
+		jobKey := client.ObjectKey{Namespace: mounted.Pod.Namespace, Name: j.generateJobName(mounted.Pod.Name, mounted.PVC.Name)}
+		j.postBackup.jobPods[jobKey] = podKey
+		j.postBackup.pendingJobs[podKey]++
+	}
+}
+
+// jobEnded runs the post-backup command of the job's pod, once all backup jobs of the pod have ended.
+// It doesn't matter if they succeeded or not.
+func (j *JobRunner) jobEnded(job FinishedJob) {
+	if j.postBackup == nil {
+		return
+	}
+	podKey, ok := j.postBackup.jobPods[client.ObjectKey{Namespace: job.Namespace, Name: job.Name}]
+	if !ok {
+		return
+	}
+	j.postBackup.pendingJobs[podKey]--
+	if j.postBackup.pendingJobs[podKey] > 0 {
+		return
+	}
+	j.runPostBackupCommand(podKey)
+}
+
+// runPostBackupCommandsWithoutJobs runs the post-backup commands of the pods that don't get any backup jobs.
+// They don't have to wait for anything once the pre-backup commands have run.
+func (j *JobRunner) runPostBackupCommandsWithoutJobs() {
+	for podKey := range j.postBackup.pods {
+		if j.postBackup.pendingJobs[podKey] == 0 {
+			j.runPostBackupCommand(podKey)
+		}
+	}
+}
+
+// RunPostBackupCommands runs the post-backup commands that haven't run yet.
+// These are the ones of pods whose jobs didn't all start, e.g. because the run was aborted.
+// It returns an error if a post-backup command failed during the run and the failure policy is PostBackupFailurePolicyFail.
+func (j *JobRunner) RunPostBackupCommands() error {
+	j.trackPostBackupPods()
+	for podKey := range j.postBackup.pods {
+		j.runPostBackupCommand(podKey)
+	}
+	return j.postBackup.err
+}
+
+func (j *JobRunner) runPostBackupCommand(podKey client.ObjectKey) {
+	pod, ok := j.postBackup.pods[podKey]
+	if !ok {
+		return
+	}
+	delete(j.postBackup.pods, podKey)
+
+	err := ExecutePostbackupCommand(j.CliCtx, pod)
+	if err == nil {
+		return
+	}
+	j.PostBackupFailures++
+	if j.CliCtx.String("post-backup-failure-policy") == PostBackupFailurePolicyIgnore {
+		logger.AppLogger(j.CliCtx.Context).WithName("postbackupExec").Error(err, "ignoring failed postbackup command", "podname", pod.Name, "namespace", pod.Namespace)
+		return
+	}
+	if j.postBackup.err == nil {
+		j.postBackup.err = fmt.Errorf("postbackup command of pod %s/%s failed: %w", pod.Namespace, pod.Name, err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 )
 
 // +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch
 
 // MountedPVC describes a PVC and the POD it belongs to.
 type MountedPVC struct {
@@ -28,25 +29,40 @@ type BackupPVCList struct {
 }
 
 // ListEligiblePVCs will list all PVCs that fullfill certain constraints.
-func ListEligiblePVCs(cliCtx *cli.Context, k8sClient client.Client) (*BackupPVCList, error) {
+func ListEligiblePVCs(cliCtx *cli.Context, k8sClient client.Client, filter Filter) (*BackupPVCList, error) {
 	log := logger.AppLogger(cliCtx.Context).WithName("PVCLister")
 
-	pods, err := listPodsWithPVCs(cliCtx, k8sClient)
+	namespaces, err := filter.namespaceMatcher(cliCtx, k8sClient)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := listPodsWithPVCs(cliCtx, k8sClient, namespaces)
 	if err != nil {
 		return nil, err
 	}
 
 	backupList := &BackupPVCList{MountedPVCs: map[string]MountedPVC{}}
+	// mounted contains all PVCs that are referenced by a pod that hasn't terminated, even those that don't match the filter
+	// or whose pod isn't running yet. So they don't get backed up as unmounted PVCs from a second pod.
+	mounted, err := listClaimedPVCs(cliCtx, k8sClient)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, tmp := range pods.Items {
 		pod := tmp
 		for _, volume := range pod.Spec.Volumes {
 			if volume.PersistentVolumeClaim != nil {
 				pvc, err := getPVCFromClaimSource(cliCtx, k8sClient, volume.PersistentVolumeClaim, &pod)
-				backupListKey := fmt.Sprintf("%s:%s", pvc.Name, pvc.Namespace)
 				if err != nil {
 					return nil, fmt.Errorf("could not get pvc for pod %s: %w", pod.Name, err)
 				}
+				backupListKey := fmt.Sprintf("%s:%s", pvc.Name, pvc.Namespace)
+				if isOwnPVC(pvc) || !filter.matchesPVC(pvc, &pod) {
+					log.V(1).Info("pvc doesn't match the filter", "pvcname", pvc.Name, "namespace", pvc.Namespace)
+					continue
+				}
 				backupList.MountedPVCs[backupListKey] = MountedPVC{Pod: &pod, PVC: pvc}
 				log.V(1).Info("found pod and pvc", "podname", pod.Name, "pvcname", pvc.Name, "namespace", pod.Namespace)
 			}
@@ -58,8 +74,11 @@ func ListEligiblePVCs(cliCtx *cli.Context, k8sClient client.Client) (*BackupPVCL
 
 	backupList.UnmountedPVCs = &v1.PersistentVolumeClaimList{}
 	for _, pvc := range allPVCs.Items {
+		if isOwnPVC(&pvc) || !namespaces.matches(pvc.Namespace) || !filter.matchesPVC(&pvc, nil) {
+			continue
+		}
 		pvcKey := fmt.Sprintf("%s:%s", pvc.Name, pvc.Namespace)
-		if _, ok := backupList.MountedPVCs[pvcKey]; !ok {
+		if !mounted[pvcKey] {
 			backupList.UnmountedPVCs.Items = append(backupList.UnmountedPVCs.Items, pvc)
 			log.V(1).Info("found unmounted PVC", "pvcname", pvc.Name, "namespace", pvc.Namespace)
 		}
@@ -68,6 +87,36 @@ func ListEligiblePVCs(cliCtx *cli.Context, k8sClient client.Client) (*BackupPVCL
 	return backupList, err
 }
 
+// listClaimedPVCs returns the keys <pvcname:namespace> of all PVCs that are referenced by a pod that hasn't terminated.
+// This includes pods that are still pending and the jobs of kopia-k8s.
+func listClaimedPVCs(cliCtx *cli.Context, k8sClient client.Client) (map[string]bool, error) {
+	pods := &v1.PodList{}
+	err := k8sClient.List(cliCtx.Context, pods)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list pods: %w", err)
+	}
+
+	claimed := map[string]bool{}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				claimed[fmt.Sprintf("%s:%s", volume.PersistentVolumeClaim.ClaimName, pod.Namespace)] = true
+			}
+		}
+	}
+	return claimed, nil
+}
+
+// isOwnPVC returns true for the PVCs kopia-k8s creates itself, e.g. the scratch PVCs of the restore tests.
+// They must never be backed up.
+func isOwnPVC(pvc *v1.PersistentVolumeClaim) bool {
+	_, ok := pvc.Labels[JobLabel]
+	return ok
+}
+
 func getPVCFromClaimSource(cliCtx *cli.Context, k8sClient client.Client, PVCSource *v1.PersistentVolumeClaimVolumeSource, pod *v1.Pod) (*v1.PersistentVolumeClaim, error) {
 	objectKey := client.ObjectKey{
 		Namespace: pod.Namespace,
@@ -77,3 +126,68 @@ func getPVCFromClaimSource(cliCtx *cli.Context, k8sClient client.Client, PVCSour
 	err := k8sClient.Get(cliCtx.Context, objectKey, pvc)
 	return pvc, err
 }
+
+// topologyLabels are the PV labels that restrict on which nodes a volume can be attached,
+// if the PV doesn't define a node affinity.
+var topologyLabels = []string{
+	v1.LabelTopologyZone,
+	v1.LabelTopologyRegion,
+	v1.LabelFailureDomainBetaZone,
+	v1.LabelFailureDomainBetaRegion,
+}
+
+// volumeAffinity returns the affinity a pod needs to be able to mount the given unmounted PVC.
+// Only volumes that can be attached to a single node at a time need an affinity.
+// It uses the node affinity of the bound PV or falls back to its topology labels.
+func volumeAffinity(cliCtx *cli.Context, k8sClient client.Client, pvc *v1.PersistentVolumeClaim) (*v1.Affinity, error) {
+	if !isReadWriteOnce(pvc) || pvc.Spec.VolumeName == "" {
+		return nil, nil
+	}
+
+	pv := &v1.PersistentVolume{}
+	err := k8sClient.Get(cliCtx.Context, client.ObjectKey{Name: pvc.Spec.VolumeName}, pv)
+	if err != nil {
+		return nil, fmt.Errorf("could not get pv for pvc %s: %w", pvc.Name, err)
+	}
+
+	if pv.Spec.NodeAffinity != nil && pv.Spec.NodeAffinity.Required != nil {
+		return &v1.Affinity{
+			NodeAffinity: &v1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: pv.Spec.NodeAffinity.Required.DeepCopy(),
+			},
+		}, nil
+	}
+
+	requirements := []v1.NodeSelectorRequirement{}
+	for _, label := range topologyLabels {
+		if value, ok := pv.Labels[label]; ok {
+			requirements = append(requirements, v1.NodeSelectorRequirement{
+				Key:      label,
+				Operator: v1.NodeSelectorOpIn,
+				Values:   []string{value},
+			})
+		}
+	}
+	if len(requirements) == 0 {
+		return nil, nil
+	}
+
+	return &v1.Affinity{
+		NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{
+					{MatchExpressions: requirements},
+				},
+			},
+		},
+	}, nil
+}
+
+func isReadWriteOnce(pvc *v1.PersistentVolumeClaim) bool {
+	for _, mode := range pvc.Spec.AccessModes {
+		if mode == v1.ReadWriteOnce || mode == v1.ReadWriteOncePod {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package k8s
+
+import (
+	"context"
+	"flag"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"github.com/go-logr/logr"
+	"github.com/urfave/cli/v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testCliContext returns a cli context without flags and with a logger that discards everything.
+func testCliContext() *cli.Context {
+	log := &atomic.Value{}
+	log.Store(logr.Discard())
+	c := cli.NewContext(cli.NewApp(), flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	c.Context = context.WithValue(context.Background(), logger.ContextKey{}, log)
+	return c
+}
+
+func TestListEligiblePVCs(t *testing.T) {
+	newPVC := func(name string, labels map[string]string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: labels}}
+	}
+	newPod := func(name, claim string, phase v1.PodPhase, labels map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: labels},
+			Spec: v1.PodSpec{Volumes: []v1.Volume{{
+				Name:         "data",
+				VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+			}}},
+			Status: v1.PodStatus{Phase: phase},
+		}
+	}
+
+	k8sClient := fake.NewClientBuilder().WithObjects(
+		newPVC("running", nil),
+		newPod("app", "running", v1.PodRunning, nil),
+		newPVC("pending", nil),
+		newPod("starting", "pending", v1.PodPending, nil),
+		newPVC("completed", nil),
+		newPod("migration", "completed", v1.PodSucceeded, nil),
+		newPVC("restore-target", nil),
+		newPod("restore", "restore-target", v1.PodRunning, map[string]string{JobLabel: "run"}),
+		newPVC("scratch", map[string]string{JobLabel: "run"}),
+		newPVC("unused", nil),
+	).Build()
+
+	list, err := ListEligiblePVCs(testCliContext(), k8sClient, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mounted []string
+	for key := range list.MountedPVCs {
+		mounted = append(mounted, key)
+	}
+	if len(mounted) != 1 || mounted[0] != "running:default" {
+		t.Errorf("got mounted PVCs %v, want [running:default]", mounted)
+	}
+
+	var unmounted []string
+	for _, pvc := range list.UnmountedPVCs.Items {
+		unmounted = append(unmounted, pvc.Name)
+	}
+	sort.Strings(unmounted)
+	if len(unmounted) != 2 || unmounted[0] != "completed" || unmounted[1] != "unused" {
+		t.Errorf("got unmounted PVCs %v, want [completed unused]", unmounted)
+	}
+}
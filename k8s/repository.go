@@ -0,0 +1,420 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	kopiav1alpha1 "git.earthnet.ch/simon.beck/kopia-k8s/api/v1alpha1"
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"github.com/urfave/cli/v2"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Repository is the resolved configuration of the kopia repository.
+type Repository struct {
+	// Type is the storage backend, e.g. kopia.StorageS3.
+	Type               string
+	S3                 kopia.S3Options
+	Filesystem         kopia.FilesystemOptions
+	SFTP               kopia.SFTPOptions
+	Azure              kopia.AzureOptions
+	GCS                kopia.GCSOptions
+	B2                 kopia.B2Options
+	EncryptionPassword string
+	// Volume contains the repository for the filesystem backend.
+	// It gets mounted into the jobs at the path of the repository.
+	Volume *v1.VolumeSource
+}
+
+//+kubebuilder:rbac:groups=kopia.earthnet.ch,resources=repositories,verbs=get;list;watch
+
+// RepositoryFromFlags returns the repository configured with the kopia flags.
+func RepositoryFromFlags(c *cli.Context) (*Repository, error) {
+	repository := &Repository{
+		Type: c.String("storage-type"),
+		S3: kopia.S3Options{
+			Bucket:                 c.String("bucket"),
+			Prefix:                 c.String("s3-prefix"),
+			Endpoint:               c.String("s3-endpoint"),
+			AccessKeyID:            c.String("access-key-id"),
+			SecretAccessKey:        c.String("secret-access-key"),
+			DisableTLS:             c.Bool("s3-disable-tls"),
+			DisableTLSVerification: c.Bool("s3-disable-tls-verification"),
+		},
+		Filesystem: kopia.FilesystemOptions{
+			Path: c.String("filesystem-path"),
+		},
+		SFTP: kopia.SFTPOptions{
+			Host:           c.String("sftp-host"),
+			Port:           c.Int("sftp-port"),
+			Path:           c.String("sftp-path"),
+			Username:       c.String("sftp-username"),
+			Password:       c.String("sftp-password"),
+			KeyFile:        c.String("sftp-key-file"),
+			KnownHostsFile: c.String("sftp-known-hosts-file"),
+		},
+		Azure: kopia.AzureOptions{
+			Container:      c.String("azure-container"),
+			Prefix:         c.String("azure-prefix"),
+			StorageAccount: c.String("azure-storage-account"),
+			StorageKey:     c.String("azure-storage-key"),
+			SASToken:       c.String("azure-sas-token"),
+			StorageDomain:  c.String("azure-storage-domain"),
+		},
+		GCS: kopia.GCSOptions{
+			Bucket:          c.String("gcs-bucket"),
+			Prefix:          c.String("gcs-prefix"),
+			CredentialsFile: c.String("gcs-credentials-file"),
+		},
+		B2: kopia.B2Options{
+			Bucket: c.String("b2-bucket"),
+			Prefix: c.String("b2-prefix"),
+			KeyID:  c.String("b2-key-id"),
+			Key:    c.String("b2-key"),
+		},
+		EncryptionPassword: c.String("encryption-password"),
+	}
+
+	if claim := c.String("filesystem-pvc"); claim != "" {
+		repository.Volume = &v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+		}
+	} else if server := c.String("filesystem-nfs-server"); server != "" {
+		repository.Volume = &v1.VolumeSource{
+			NFS: &v1.NFSVolumeSource{Server: server, Path: c.String("filesystem-nfs-path")},
+		}
+	}
+
+	return repository, repository.Validate()
+}
+
+// ResolveRepository reads the given Repository resource and the secrets it references.
+func ResolveRepository(ctx context.Context, k8sClient client.Client, key client.ObjectKey) (*Repository, error) {
+	repository := &kopiav1alpha1.Repository{}
+	err := k8sClient.Get(ctx, key, repository)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get repository %s: %w", key, err)
+	}
+
+	resolved := &Repository{Type: repository.Spec.Type}
+	secrets := []secretValueRef{
+		{&repository.Spec.EncryptionPasswordSecretRef, &resolved.EncryptionPassword},
+	}
+
+	switch {
+	case repository.Spec.Type == kopiav1alpha1.BackendS3 && repository.Spec.S3 != nil:
+		s3 := repository.Spec.S3
+		resolved.S3 = kopia.S3Options{
+			Bucket:                 s3.Bucket,
+			Prefix:                 s3.Prefix,
+			Endpoint:               s3.Endpoint,
+			DisableTLS:             s3.TLS.Disabled,
+			DisableTLSVerification: s3.TLS.InsecureSkipVerify,
+		}
+		secrets = append(secrets,
+			secretValueRef{&s3.AccessKeyIDSecretRef, &resolved.S3.AccessKeyID},
+			secretValueRef{&s3.SecretAccessKeySecretRef, &resolved.S3.SecretAccessKey},
+		)
+	case repository.Spec.Type == kopiav1alpha1.BackendFilesystem && repository.Spec.Filesystem != nil:
+		filesystem := repository.Spec.Filesystem
+		resolved.Filesystem = kopia.FilesystemOptions{Path: filesystem.Path}
+		if filesystem.PersistentVolumeClaim != nil {
+			resolved.Volume = &v1.VolumeSource{PersistentVolumeClaim: filesystem.PersistentVolumeClaim.DeepCopy()}
+		} else if filesystem.NFS != nil {
+			resolved.Volume = &v1.VolumeSource{NFS: filesystem.NFS.DeepCopy()}
+		}
+	case repository.Spec.Type == kopiav1alpha1.BackendSFTP && repository.Spec.SFTP != nil:
+		sftp := repository.Spec.SFTP
+		resolved.SFTP = kopia.SFTPOptions{
+			Host:           sftp.Host,
+			Port:           sftp.Port,
+			Path:           sftp.Path,
+			Username:       sftp.Username,
+			KnownHostsData: sftp.KnownHosts,
+		}
+		if resolved.SFTP.Port == 0 {
+			resolved.SFTP.Port = 22
+		}
+		if sftp.KeySecretRef != nil {
+			secrets = append(secrets, secretValueRef{sftp.KeySecretRef, &resolved.SFTP.KeyData})
+		} else if sftp.PasswordSecretRef != nil {
+			secrets = append(secrets, secretValueRef{sftp.PasswordSecretRef, &resolved.SFTP.Password})
+		}
+	case repository.Spec.Type == kopiav1alpha1.BackendAzure && repository.Spec.Azure != nil:
+		azure := repository.Spec.Azure
+		resolved.Azure = kopia.AzureOptions{
+			Container:      azure.Container,
+			Prefix:         azure.Prefix,
+			StorageAccount: azure.StorageAccount,
+			StorageDomain:  azure.StorageDomain,
+		}
+		if azure.SASTokenSecretRef != nil {
+			secrets = append(secrets, secretValueRef{azure.SASTokenSecretRef, &resolved.Azure.SASToken})
+		} else if azure.StorageKeySecretRef != nil {
+			secrets = append(secrets, secretValueRef{azure.StorageKeySecretRef, &resolved.Azure.StorageKey})
+		}
+	case repository.Spec.Type == kopiav1alpha1.BackendGCS && repository.Spec.GCS != nil:
+		gcs := repository.Spec.GCS
+		resolved.GCS = kopia.GCSOptions{
+			Bucket: gcs.Bucket,
+			Prefix: gcs.Prefix,
+		}
+		secrets = append(secrets, secretValueRef{&gcs.CredentialsSecretRef, &resolved.GCS.CredentialsData})
+	case repository.Spec.Type == kopiav1alpha1.BackendB2 && repository.Spec.B2 != nil:
+		b2 := repository.Spec.B2
+		resolved.B2 = kopia.B2Options{
+			Bucket: b2.Bucket,
+			Prefix: b2.Prefix,
+		}
+		secrets = append(secrets,
+			secretValueRef{&b2.KeyIDSecretRef, &resolved.B2.KeyID},
+			secretValueRef{&b2.KeySecretRef, &resolved.B2.Key},
+		)
+	default:
+		return nil, fmt.Errorf("repository %s: backend %q is not configured", key, repository.Spec.Type)
+	}
+
+	for _, ref := range secrets {
+		*ref.value, err = secretValue(ctx, k8sClient, key.Namespace, ref.selector)
+		if err != nil {
+			return nil, fmt.Errorf("repository %s: %w", key, err)
+		}
+	}
+
+	err = resolved.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("repository %s: %w", key, err)
+	}
+	return resolved, nil
+}
+
+type secretValueRef struct {
+	selector *v1.SecretKeySelector
+	value    *string
+}
+
+func secretValue(ctx context.Context, k8sClient client.Client, namespace string, selector *v1.SecretKeySelector) (string, error) {
+	secret := &v1.Secret{}
+	err := k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: selector.Name}, secret)
+	if err != nil {
+		return "", fmt.Errorf("cannot get secret %s: %w", selector.Name, err)
+	}
+	value, ok := secret.Data[selector.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", selector.Name, selector.Key)
+	}
+	return string(value), nil
+}
+
+// Storage returns the kopia storage backend of the repository.
+// It returns nil if the type is unknown.
+func (r *Repository) Storage() kopia.Storage {
+	switch r.Type {
+	case kopia.StorageS3:
+		return r.S3
+	case kopia.StorageFilesystem:
+		return r.Filesystem
+	case kopia.StorageSFTP:
+		return r.SFTP
+	case kopia.StorageAzure:
+		return r.Azure
+	case kopia.StorageGCS:
+		return r.GCS
+	case kopia.StorageB2:
+		return r.B2
+	}
+	return nil
+}
+
+// Validate returns an error if the storage type is unknown or the storage isn't configured completely.
+// It also registers the credentials, so that they get masked in the logs.
+func (r *Repository) Validate() error {
+	storage := r.Storage()
+	if storage == nil {
+		return fmt.Errorf("unknown storage type %q", r.Type)
+	}
+	logger.RegisterSecret(append(storage.Secrets(), r.EncryptionPassword)...)
+	return storage.Validate()
+}
+
+// credentials returns the content of the credentials secret for the jobs.
+func (r *Repository) credentials() (map[string][]byte, error) {
+	credentials := map[string][]byte{
+		CredentialsEncryptionPassword: []byte(r.EncryptionPassword),
+	}
+	switch r.Type {
+	case kopia.StorageS3:
+		credentials[CredentialsAccessKeyID] = []byte(r.S3.AccessKeyID)
+		credentials[CredentialsSecretAccessKey] = []byte(r.S3.SecretAccessKey)
+	case kopia.StorageSFTP:
+		key, err := fileOrData(r.SFTP.KeyFile, r.SFTP.KeyData)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read sftp key: %w", err)
+		}
+		knownHosts, err := fileOrData(r.SFTP.KnownHostsFile, r.SFTP.KnownHostsData)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read known hosts: %w", err)
+		}
+		credentials[CredentialsKnownHosts] = knownHosts
+		if len(key) > 0 {
+			credentials[CredentialsSFTPKey] = key
+		} else {
+			credentials[CredentialsSFTPPassword] = []byte(r.SFTP.Password)
+		}
+	case kopia.StorageAzure:
+		if r.Azure.SASToken != "" {
+			credentials[CredentialsAzureSASToken] = []byte(r.Azure.SASToken)
+		} else {
+			credentials[CredentialsAzureStorageKey] = []byte(r.Azure.StorageKey)
+		}
+	case kopia.StorageGCS:
+		gcsCredentials, err := fileOrData(r.GCS.CredentialsFile, r.GCS.CredentialsData)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read gcs credentials: %w", err)
+		}
+		credentials[CredentialsGCS] = gcsCredentials
+	case kopia.StorageB2:
+		credentials[CredentialsB2KeyID] = []byte(r.B2.KeyID)
+		credentials[CredentialsB2Key] = []byte(r.B2.Key)
+	}
+	return credentials, nil
+}
+
+func fileOrData(file, data string) ([]byte, error) {
+	if data != "" || file == "" {
+		return []byte(data), nil
+	}
+	return os.ReadFile(file)
+}
+
+// JobEnv returns the environment variables that configure the repository for kopia-k8s running in a job.
+// The credentials are referenced from the given secret in the job's namespace.
+func (r *Repository) JobEnv(credentialsSecret string) []v1.EnvVar {
+	env := []v1.EnvVar{
+		secretEnvVar("KK_ENCRYPTION_PASSWORD", credentialsSecret, CredentialsEncryptionPassword),
+		{
+			Name:  "KK_STORAGE_TYPE",
+			Value: r.Type,
+		},
+	}
+
+	switch r.Type {
+	case kopia.StorageFilesystem:
+		env = append(env, v1.EnvVar{Name: "KK_FILESYSTEM_PATH", Value: r.Filesystem.Path})
+	case kopia.StorageSFTP:
+		env = append(env,
+			v1.EnvVar{Name: "KK_SFTP_HOST", Value: r.SFTP.Host},
+			v1.EnvVar{Name: "KK_SFTP_PORT", Value: strconv.Itoa(r.SFTP.Port)},
+			v1.EnvVar{Name: "KK_SFTP_PATH", Value: r.SFTP.Path},
+			v1.EnvVar{Name: "KK_SFTP_USERNAME", Value: r.SFTP.Username},
+			v1.EnvVar{Name: "KK_SFTP_KNOWN_HOSTS_FILE", Value: path.Join(CredentialsMountPath, CredentialsKnownHosts)},
+		)
+		if r.SFTP.KeyFile != "" || r.SFTP.KeyData != "" {
+			env = append(env, v1.EnvVar{Name: "KK_SFTP_KEY_FILE", Value: path.Join(CredentialsMountPath, CredentialsSFTPKey)})
+		} else {
+			env = append(env, secretEnvVar("KK_SFTP_PASSWORD", credentialsSecret, CredentialsSFTPPassword))
+		}
+	case kopia.StorageAzure:
+		env = append(env,
+			v1.EnvVar{Name: "KK_AZURE_CONTAINER", Value: r.Azure.Container},
+			v1.EnvVar{Name: "KK_AZURE_STORAGE_ACCOUNT", Value: r.Azure.StorageAccount},
+		)
+		if r.Azure.Prefix != "" {
+			env = append(env, v1.EnvVar{Name: "KK_AZURE_PREFIX", Value: r.Azure.Prefix})
+		}
+		if r.Azure.StorageDomain != "" {
+			env = append(env, v1.EnvVar{Name: "KK_AZURE_STORAGE_DOMAIN", Value: r.Azure.StorageDomain})
+		}
+		if r.Azure.SASToken != "" {
+			env = append(env, secretEnvVar("KK_AZURE_SAS_TOKEN", credentialsSecret, CredentialsAzureSASToken))
+		} else {
+			env = append(env, secretEnvVar("KK_AZURE_STORAGE_KEY", credentialsSecret, CredentialsAzureStorageKey))
+		}
+	case kopia.StorageGCS:
+		env = append(env,
+			v1.EnvVar{Name: "KK_GCS_BUCKET", Value: r.GCS.Bucket},
+			v1.EnvVar{Name: "KK_GCS_CREDENTIALS_FILE", Value: path.Join(CredentialsMountPath, CredentialsGCS)},
+		)
+		if r.GCS.Prefix != "" {
+			env = append(env, v1.EnvVar{Name: "KK_GCS_PREFIX", Value: r.GCS.Prefix})
+		}
+	case kopia.StorageB2:
+		env = append(env,
+			v1.EnvVar{Name: "KK_B2_BUCKET", Value: r.B2.Bucket},
+			secretEnvVar("KK_B2_KEY_ID", credentialsSecret, CredentialsB2KeyID),
+			secretEnvVar("KK_B2_KEY", credentialsSecret, CredentialsB2Key),
+		)
+		if r.B2.Prefix != "" {
+			env = append(env, v1.EnvVar{Name: "KK_B2_PREFIX", Value: r.B2.Prefix})
+		}
+	case kopia.StorageS3:
+		env = append(env,
+			secretEnvVar("AWS_ACCESS_KEY_ID", credentialsSecret, CredentialsAccessKeyID),
+			secretEnvVar("AWS_SECRET_ACCESS_KEY", credentialsSecret, CredentialsSecretAccessKey),
+			v1.EnvVar{Name: "KK_BUCKET", Value: r.S3.Bucket},
+			v1.EnvVar{Name: "KK_ENDPOINT", Value: r.S3.Endpoint},
+		)
+		if r.S3.Prefix != "" {
+			env = append(env, v1.EnvVar{Name: "KK_S3_PREFIX", Value: r.S3.Prefix})
+		}
+		if r.S3.DisableTLS {
+			env = append(env, v1.EnvVar{Name: "KK_S3_DISABLE_TLS", Value: strconv.FormatBool(true)})
+		}
+		if r.S3.DisableTLSVerification {
+			env = append(env, v1.EnvVar{Name: "KK_S3_DISABLE_TLS_VERIFICATION", Value: strconv.FormatBool(true)})
+		}
+	}
+	return env
+}
+
+// JobVolumes returns the volumes and mounts the jobs need to access the repository.
+func (r *Repository) JobVolumes(credentialsSecret string) ([]v1.Volume, []v1.VolumeMount) {
+	switch {
+	case r.Type == kopia.StorageFilesystem && r.Volume != nil:
+		volume := v1.Volume{
+			Name:         "repository",
+			VolumeSource: *r.Volume,
+		}
+		mount := v1.VolumeMount{
+			Name:      "repository",
+			MountPath: r.Filesystem.Path,
+		}
+		return []v1.Volume{volume}, []v1.VolumeMount{mount}
+	case r.Type == kopia.StorageSFTP || r.Type == kopia.StorageGCS:
+		// These backends need their credentials as files.
+		mode := int32(0400)
+		volume := v1.Volume{
+			Name: "credentials",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName:  credentialsSecret,
+					DefaultMode: &mode,
+				},
+			},
+		}
+		mount := v1.VolumeMount{
+			Name:      "credentials",
+			MountPath: CredentialsMountPath,
+			ReadOnly:  true,
+		}
+		return []v1.Volume{volume}, []v1.VolumeMount{mount}
+	}
+	return nil, nil
+}
+
+func secretEnvVar(name, secret, key string) v1.EnvVar {
+	return v1.EnvVar{
+		Name: name,
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: secret},
+				Key:                  key,
+			},
+		},
+	}
+}
@@ -0,0 +1,104 @@
+package k8s
+
+import (
+	"fmt"
+	"path"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RestoreRequest describes which snapshot of a PVC should be restored where.
+type RestoreRequest struct {
+	Namespace string
+	// PVC is the name of the backed up PVC.
+	PVC string
+	// TargetPVC is the PVC the snapshot gets restored into.
+	TargetPVC string
+	// Snapshot is either a snapshot ID or "latest".
+	Snapshot     string
+	Overwrite    bool
+	SkipExisting bool
+	// Verify compares the restored files with the snapshot.
+	Verify bool
+}
+
+// RunAndWatchRestoreJob starts a restore job for the given request.
+// It will block until the job has either finished or failed.
+func (j *JobRunner) RunAndWatchRestoreJob(req RestoreRequest) error {
+	log := logger.AppLogger(j.CliCtx.Context).WithName("RestoreAndWatch")
+
+	targetPVC := &v1.PersistentVolumeClaim{}
+	err := j.K8sClient.Get(j.CliCtx.Context, client.ObjectKey{Namespace: req.Namespace, Name: req.TargetPVC}, targetPVC)
+	if err != nil {
+		return fmt.Errorf("cannot get target pvc %s: %w", req.TargetPVC, err)
+	}
+
+	pod, err := findRunningPodForPVC(j.CliCtx, j.K8sClient, targetPVC)
+	if err != nil {
+		return err
+	}
+
+	podName := ""
+	var affinity *v1.Affinity
+	if pod != nil {
+		podName = pod.Name
+		affinity = podAffinity(pod)
+	} else {
+		affinity, err = volumeAffinity(j.CliCtx, j.K8sClient, targetPVC)
+		if err != nil {
+			return err
+		}
+	}
+	log.Info("starting restore", "pvcname", req.PVC, "targetpvc", targetPVC.Name, "namespace", req.Namespace, "snapshot", req.Snapshot, "podname", podName)
+
+	createServiceAccount(*j.CliCtx, j.K8sClient, req.Namespace)
+	job := j.newRestoreJob(req, targetPVC, affinity)
+	defer j.deleteCredentialsSecrets()
+	err = j.createJob(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = j.waitForJob(job)
+	return err
+}
+
+// waitForJob blocks until the given job has ended.
+// It returns an error if the job didn't succeed, but the finished job is returned in any case.
+// The job has to be the only one of the JobRunner that is still running.
+func (j *JobRunner) waitForJob(job *batchv1.Job) (FinishedJob, error) {
+	finished := <-j.finished
+	if finished.Status != JobSucceeded {
+		return finished, fmt.Errorf("job %s/%s %s", job.Namespace, job.Name, finished.Status)
+	}
+	return finished, nil
+}
+
+func (j JobRunner) newRestoreJob(req RestoreRequest, targetPVC *v1.PersistentVolumeClaim, affinity *v1.Affinity) *batchv1.Job {
+	args := []string{
+		"kopia",
+		"restore",
+		"--snapshot",
+		req.Snapshot,
+		"--source-host",
+		req.Namespace,
+		"--source-path",
+		path.Join("/data", req.PVC),
+		"--target",
+		path.Join("/data", targetPVC.Name),
+	}
+	if req.Overwrite {
+		args = append(args, "--overwrite")
+	}
+	if req.SkipExisting {
+		args = append(args, "--skip-existing")
+	}
+	if req.Verify {
+		args = append(args, "--verify")
+	}
+
+	return j.newJob(j.generateJobName("restore", targetPVC.Name), JobTypeRestore, targetPVC, affinity, args)
+}
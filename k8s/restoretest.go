@@ -0,0 +1,146 @@
+package k8s
+
+import (
+	"fmt"
+	"math"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RestoreTestRequest describes which snapshot of a PVC should be test restored.
+type RestoreTestRequest struct {
+	Namespace string
+	// PVC is the name of the backed up PVC.
+	PVC        string
+	SnapshotID string
+	// SnapshotSize is used to size the scratch PVC if the backed up PVC doesn't exist anymore.
+	SnapshotSize int64
+	// StorageClass of the scratch PVC, defaults to the one of the backed up PVC.
+	StorageClass string
+}
+
+// RestoreTestResult records whether the test restore of a PVC passed.
+type RestoreTestResult struct {
+	Namespace  string `json:"namespace"`
+	PVC        string `json:"pvc"`
+	SnapshotID string `json:"snapshotID"`
+	Passed     bool   `json:"passed"`
+	Message    string `json:"message,omitempty"`
+}
+
+// RunRestoreTest restores the snapshot into a scratch PVC and verifies the restored files against the snapshot.
+// It blocks until the restore job has ended and deletes the job and the scratch PVC afterwards.
+func (j *JobRunner) RunRestoreTest(req RestoreTestRequest) RestoreTestResult {
+	log := logger.AppLogger(j.CliCtx.Context).WithName("RestoreTest")
+	result := RestoreTestResult{
+		Namespace:  req.Namespace,
+		PVC:        req.PVC,
+		SnapshotID: req.SnapshotID,
+	}
+
+	scratch, err := j.newScratchPVC(req)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	log.Info("starting restore test", "pvcname", req.PVC, "namespace", req.Namespace, "snapshot", req.SnapshotID, "scratchpvc", scratch.Name)
+
+	err = j.K8sClient.Create(j.CliCtx.Context, scratch)
+	if err != nil {
+		result.Message = fmt.Sprintf("cannot create scratch pvc: %v", err)
+		return result
+	}
+	defer j.deleteScratchResource(scratch)
+
+	createServiceAccount(*j.CliCtx, j.K8sClient, req.Namespace)
+	job := j.newRestoreJob(RestoreRequest{
+		Namespace: req.Namespace,
+		PVC:       req.PVC,
+		TargetPVC: scratch.Name,
+		Snapshot:  req.SnapshotID,
+		Verify:    true,
+	}, scratch, nil)
+	job.Name = j.generateJobName("restore-test", req.PVC)
+	// A failed restore test shouldn't be repeated, the result is reported instead.
+	backoffLimit := int32(0)
+	job.Spec.BackoffLimit = &backoffLimit
+	job.Spec.Template.Spec.RestartPolicy = v1.RestartPolicyNever
+	defer j.deleteCredentialsSecrets()
+	err = j.createJob(job)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	defer j.deleteScratchResource(job)
+
+	_, err = j.waitForJob(job)
+	if err != nil {
+		result.Message = fmt.Sprintf("%v, see the logs of the job for details", err)
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+// newScratchPVC returns a PVC for the test restore with the size and storage class of the backed up PVC.
+func (j *JobRunner) newScratchPVC(req RestoreTestRequest) (*v1.PersistentVolumeClaim, error) {
+	size := scratchSize(req.SnapshotSize)
+	var storageClass *string
+
+	original := &v1.PersistentVolumeClaim{}
+	err := j.K8sClient.Get(j.CliCtx.Context, client.ObjectKey{Namespace: req.Namespace, Name: req.PVC}, original)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("cannot get pvc %s: %w", req.PVC, err)
+	}
+	if err == nil {
+		if request, ok := original.Spec.Resources.Requests[v1.ResourceStorage]; ok && request.Cmp(size) > 0 {
+			size = request
+		}
+		storageClass = original.Spec.StorageClassName
+	}
+	if req.StorageClass != "" {
+		storageClass = &req.StorageClass
+	}
+
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      j.generateJobName("scratch", req.PVC),
+			Namespace: req.Namespace,
+			Labels: map[string]string{
+				JobLabel: j.CliCtx.String("uuid"),
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			StorageClassName: storageClass,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: size,
+				},
+			},
+		},
+	}, nil
+}
+
+// scratchSize returns the size of the snapshot plus some headroom for the filesystem, rounded up to full GiB.
+func scratchSize(snapshotSize int64) resource.Quantity {
+	gib := math.Ceil(float64(snapshotSize) * 1.2 / (1 << 30))
+	if gib < 1 {
+		gib = 1
+	}
+	return *resource.NewQuantity(int64(gib)*(1<<30), resource.BinarySI)
+}
+
+// deleteScratchResource deletes a resource of the restore test, including the pods of jobs.
+func (j *JobRunner) deleteScratchResource(obj client.Object) {
+	backgroundDelete := metav1.DeletePropagationBackground
+	err := j.K8sClient.Delete(j.CliCtx.Context, obj, &client.DeleteOptions{PropagationPolicy: &backgroundDelete})
+	if client.IgnoreNotFound(err) != nil {
+		logger.AppLogger(j.CliCtx.Context).Error(err, "cannot clean up restore test", "name", obj.GetName(), "namespace", obj.GetNamespace())
+	}
+}
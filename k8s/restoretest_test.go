@@ -0,0 +1,24 @@
+package k8s
+
+import "testing"
+
+func TestScratchSize(t *testing.T) {
+	tests := map[string]struct {
+		snapshotSize int64
+		want         string
+	}{
+		"empty snapshot":      {snapshotSize: 0, want: "1Gi"},
+		"small snapshot":      {snapshotSize: 100 << 20, want: "1Gi"},
+		"exactly fits":        {snapshotSize: 5 << 30, want: "6Gi"},
+		"rounds up":           {snapshotSize: 10 << 30, want: "12Gi"},
+		"rounds up fractions": {snapshotSize: 1 << 30, want: "2Gi"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := scratchSize(tt.snapshotSize)
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got.String(), tt.want)
+			}
+		})
+	}
+}
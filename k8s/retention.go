@@ -0,0 +1,42 @@
+package k8s
+
+import (
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// retentionPolicy returns the retention policy for the given PVC.
+// The retention annotation on the namespace overrides the default policy
+// and the annotation on the PVC overrides both.
+func (j JobRunner) retentionPolicy(pvc *v1.PersistentVolumeClaim) kopia.RetentionPolicy {
+	log := logger.AppLogger(j.CliCtx.Context).WithName("retention")
+	annotation := j.CliCtx.String("retention-annotation")
+
+	policy := j.Retention
+
+	namespace := &v1.Namespace{}
+	err := j.K8sClient.Get(j.CliCtx.Context, client.ObjectKey{Name: pvc.Namespace}, namespace)
+	if err != nil {
+		log.Error(err, "cannot get namespace, ignoring its retention annotation", "namespace", pvc.Namespace)
+	} else if value, ok := namespace.Annotations[annotation]; ok {
+		policy = j.parseRetentionAnnotation(value, policy, "namespace", namespace.Name)
+	}
+
+	if value, ok := pvc.Annotations[annotation]; ok {
+		policy = j.parseRetentionAnnotation(value, policy, "pvcname", pvc.Name, "namespace", pvc.Namespace)
+	}
+
+	return policy
+}
+
+func (j JobRunner) parseRetentionAnnotation(value string, base kopia.RetentionPolicy, keysAndValues ...interface{}) kopia.RetentionPolicy {
+	policy, err := kopia.ParseRetentionPolicy(value, base)
+	if err != nil {
+		logger.AppLogger(j.CliCtx.Context).WithName("retention").Error(err, "invalid retention annotation, ignoring it", keysAndValues...)
+	}
+	return policy
+}
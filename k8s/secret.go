@@ -0,0 +1,199 @@
+package k8s
+
+import (
+	"fmt"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// CredentialsAccessKeyID is the key of the access key ID in the credentials secret.
+	CredentialsAccessKeyID = "access-key-id"
+	// CredentialsSecretAccessKey is the key of the secret access key in the credentials secret.
+	CredentialsSecretAccessKey = "secret-access-key"
+	// CredentialsEncryptionPassword is the key of the encryption password in the credentials secret.
+	CredentialsEncryptionPassword = "encryption-password"
+	// CredentialsSFTPPassword is the key of the SFTP password in the credentials secret.
+	CredentialsSFTPPassword = "sftp-password"
+	// CredentialsSFTPKey is the key of the SFTP private key in the credentials secret.
+	CredentialsSFTPKey = "sftp-key"
+	// CredentialsKnownHosts is the key of the SFTP known_hosts entries in the credentials secret.
+	CredentialsKnownHosts = "known-hosts"
+	// CredentialsAzureStorageKey is the key of the Azure storage account key in the credentials secret.
+	CredentialsAzureStorageKey = "azure-storage-key"
+	// CredentialsAzureSASToken is the key of the Azure SAS token in the credentials secret.
+	CredentialsAzureSASToken = "azure-sas-token"
+	// CredentialsGCS is the key of the GCS service account JSON key in the credentials secret.
+	CredentialsGCS = "gcs-credentials.json"
+	// CredentialsB2KeyID is the key of the B2 application key ID in the credentials secret.
+	CredentialsB2KeyID = "b2-key-id"
+	// CredentialsB2Key is the key of the B2 application key in the credentials secret.
+	CredentialsB2Key = "b2-key"
+
+	// CredentialsMountPath is where the credentials secret is mounted in the jobs, if a backend needs files.
+	CredentialsMountPath = "/etc/kopia-k8s/credentials"
+)
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+
+// credentialsSecretName returns the name of the secret the jobs get the credentials from.
+// It's either the secret given with --credentials-secret or the one created for this run.
+func (j *JobRunner) credentialsSecretName() string {
+	if name := j.CliCtx.String("credentials-secret"); name != "" {
+		return name
+	}
+	return j.generateJobName("credentials")
+}
+
+// createJob creates the job and the credentials secret in its namespace, if it doesn't exist yet.
+// The secret is shared by all jobs of the run in a namespace. The jobs own it, so it gets garbage collected
+// once they are deleted, even if the run gets killed. deleteCredentialsSecrets deletes it earlier if possible.
+func (j *JobRunner) createJob(job *batchv1.Job) error {
+	if j.CliCtx.String("credentials-secret") == "" {
+		err := j.ensureCredentialsSecret(job.Namespace)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A job with the same name belongs to another run or another PVC, it must not be mistaken for this one.
+	j.subscribe(job)
+	err := j.K8sClient.Create(j.CliCtx.Context, job)
+	if err != nil {
+		j.unsubscribe(job)
+		return fmt.Errorf("cannot create job %s/%s: %w", job.Namespace, job.Name, err)
+	}
+
+	if j.CliCtx.String("credentials-secret") == "" {
+		err = j.addCredentialsSecretOwner(job)
+		if err != nil {
+			logger.AppLogger(j.CliCtx.Context).Error(err, "cannot make the job the owner of the credentials secret", "name", job.Name, "namespace", job.Namespace)
+		}
+	}
+	return nil
+}
+
+// ensureCredentialsSecret creates the credentials secret of the run in the namespace.
+// An existing secret gets the current credentials, so rotated credentials are picked up.
+func (j *JobRunner) ensureCredentialsSecret(namespace string) error {
+	if _, ok := j.credentialsSecrets[namespace]; ok {
+		return nil
+	}
+
+	credentials, err := j.Repository.credentials()
+	if err != nil {
+		return err
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      j.credentialsSecretName(),
+			Namespace: namespace,
+			Labels: map[string]string{
+				JobLabel: j.CliCtx.String("uuid"),
+			},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: credentials,
+	}
+
+	err = j.K8sClient.Create(j.CliCtx.Context, secret)
+	if errors.IsAlreadyExists(err) {
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			existing := &v1.Secret{}
+			err := j.K8sClient.Get(j.CliCtx.Context, client.ObjectKeyFromObject(secret), existing)
+			if err != nil {
+				return err
+			}
+			existing.Data = credentials
+			return j.K8sClient.Update(j.CliCtx.Context, existing)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create credentials secret: %w", err)
+	}
+
+	if j.credentialsSecrets == nil {
+		j.credentialsSecrets = map[string][]client.ObjectKey{}
+	}
+	j.credentialsSecrets[namespace] = nil
+	return nil
+}
+
+// addCredentialsSecretOwner adds the job to the owners of the credentials secret in its namespace.
+func (j *JobRunner) addCredentialsSecretOwner(job *batchv1.Job) error {
+	j.credentialsSecrets[job.Namespace] = append(j.credentialsSecrets[job.Namespace], client.ObjectKeyFromObject(job))
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret := &v1.Secret{}
+		err := j.K8sClient.Get(j.CliCtx.Context, client.ObjectKey{Namespace: job.Namespace, Name: j.credentialsSecretName()}, secret)
+		if err != nil {
+			return err
+		}
+		secret.OwnerReferences = append(secret.OwnerReferences, metav1.OwnerReference{
+			APIVersion: batchv1.SchemeGroupVersion.String(),
+			Kind:       "Job",
+			Name:       job.Name,
+			UID:        job.UID,
+		})
+		return j.K8sClient.Update(j.CliCtx.Context, secret)
+	})
+}
+
+// deleteCredentialsSecrets deletes the credentials secrets of the run whose jobs have all finished or are gone.
+// If a job still needs its secret, e.g. a pending one that was skipped, the secret is left to the garbage collector,
+// which deletes it together with the last job.
+func (j *JobRunner) deleteCredentialsSecrets() {
+	log := logger.AppLogger(j.CliCtx.Context)
+	for namespace, jobs := range j.credentialsSecrets {
+		if job := j.unfinishedJob(jobs); job != "" {
+			log.Info("keeping credentials secret until the job is deleted", "namespace", namespace, "job", job)
+			delete(j.credentialsSecrets, namespace)
+			continue
+		}
+
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      j.credentialsSecretName(),
+				Namespace: namespace,
+			},
+		}
+		err := j.K8sClient.Delete(j.CliCtx.Context, secret)
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "cannot delete credentials secret", "name", secret.Name, "namespace", namespace)
+			continue
+		}
+		delete(j.credentialsSecrets, namespace)
+	}
+}
+
+// unfinishedJob returns the name of the first of the jobs that still exists and hasn't finished.
+// Jobs that can't be fetched count as unfinished.
+func (j *JobRunner) unfinishedJob(jobs []client.ObjectKey) string {
+	for _, key := range jobs {
+		job := &batchv1.Job{}
+		err := j.K8sClient.Get(j.CliCtx.Context, key, job)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil || !jobFinished(job) {
+			return key.Name
+		}
+	}
+	return ""
+}
+
+// jobFinished returns true once the job completed or failed for good, so it won't start any more pods.
+func jobFinished(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
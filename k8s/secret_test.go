@@ -0,0 +1,84 @@
+package k8s
+
+import (
+	"testing"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCredentialsSecretLifecycle(t *testing.T) {
+	cliCtx := testCliContext()
+	k8sClient := fake.NewClientBuilder().Build()
+	j := &JobRunner{
+		CliCtx:    cliCtx,
+		K8sClient: k8sClient,
+		RunID:     "1a2b3c4d-0000-0000-0000-000000000000",
+		Repository: &Repository{
+			Type:               kopia.StorageFilesystem,
+			Filesystem:         kopia.FilesystemOptions{Path: "/repository"},
+			EncryptionPassword: "password",
+		},
+	}
+	newJob := func(name string) *batchv1.Job {
+		return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}
+	}
+	setCondition := func(name string, condition batchv1.JobConditionType) {
+		job := &batchv1.Job{}
+		if err := k8sClient.Get(cliCtx.Context, client.ObjectKey{Namespace: "default", Name: name}, job); err != nil {
+			t.Fatal(err)
+		}
+		job.Status.Conditions = []batchv1.JobCondition{{Type: condition, Status: v1.ConditionTrue}}
+		if err := k8sClient.Update(cliCtx.Context, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secretKey := client.ObjectKey{Namespace: "default", Name: j.credentialsSecretName()}
+
+	for _, name := range []string{"done", "pending"} {
+		job := newJob(name)
+		if err := j.createJob(job); err != nil {
+			t.Fatal(err)
+		}
+		defer j.unsubscribe(job)
+	}
+	if err := j.createJob(newJob("done")); err == nil {
+		t.Error("expected an error for an existing job")
+	}
+
+	secret := &v1.Secret{}
+	if err := k8sClient.Get(cliCtx.Context, secretKey, secret); err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data[CredentialsEncryptionPassword]) != "password" {
+		t.Errorf("got encryption password %q", secret.Data[CredentialsEncryptionPassword])
+	}
+	var owners []string
+	for _, owner := range secret.OwnerReferences {
+		if owner.Kind != "Job" || owner.APIVersion != "batch/v1" {
+			t.Errorf("unexpected owner %+v", owner)
+		}
+		owners = append(owners, owner.Name)
+	}
+	if len(owners) != 2 || owners[0] != "done" || owners[1] != "pending" {
+		t.Errorf("got owners %v, want [done pending]", owners)
+	}
+
+	setCondition("done", batchv1.JobComplete)
+	j.deleteCredentialsSecrets()
+	if err := k8sClient.Get(cliCtx.Context, secretKey, &v1.Secret{}); err != nil {
+		t.Errorf("secret of a pending job got deleted: %v", err)
+	}
+
+	j.credentialsSecrets = map[string][]client.ObjectKey{"default": {{Namespace: "default", Name: "done"}, {Namespace: "default", Name: "pending"}}}
+	setCondition("pending", batchv1.JobFailed)
+	j.deleteCredentialsSecrets()
+	if err := k8sClient.Get(cliCtx.Context, secretKey, &v1.Secret{}); !apierrors.IsNotFound(err) {
+		t.Errorf("secret wasn't deleted once all jobs finished: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package k8s
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// RunAndWatchVerifyJob starts a job in the given namespace that verifies the snapshots in the repository.
+// It will block until the job has either finished or failed and returns the report of the job, if there's one.
+func (j *JobRunner) RunAndWatchVerifyJob(namespace string, opts kopia.VerifyOptions) (*kopia.VerifyReport, error) {
+	log := logger.AppLogger(j.CliCtx.Context).WithName("VerifyAndWatch")
+	log.Info("starting verification", "namespace", namespace, "filesPercent", opts.FilesPercent, "maxDuration", opts.MaxDuration.String())
+
+	createServiceAccount(*j.CliCtx, j.K8sClient, namespace)
+	job := j.newVerifyJob(namespace, opts)
+	defer j.deleteCredentialsSecrets()
+	err := j.createJob(job)
+	if err != nil {
+		return nil, err
+	}
+
+	finished, err := j.waitForJob(job)
+	if finished.Message == "" {
+		return nil, err
+	}
+	report := &kopia.VerifyReport{}
+	parseErr := json.Unmarshal([]byte(finished.Message), report)
+	if parseErr != nil {
+		log.Error(parseErr, "could not parse verify report", "name", job.Name, "namespace", job.Namespace)
+		return nil, err
+	}
+	return report, err
+}
+
+func (j JobRunner) newVerifyJob(namespace string, opts kopia.VerifyOptions) *batchv1.Job {
+	args := []string{
+		"kopia",
+		"verify",
+		"--verify-files-percent",
+		strconv.FormatFloat(opts.FilesPercent, 'f', -1, 64),
+		"--verify-max-duration",
+		opts.MaxDuration.String(),
+		"--result-file",
+		"/dev/termination-log",
+	}
+
+	job := j.newRepositoryJob(j.generateJobName("verify"), namespace, JobTypeVerify, args)
+	// A verification that found errors shouldn't be repeated.
+	backoffLimit := int32(0)
+	job.Spec.BackoffLimit = &backoffLimit
+	job.Spec.Template.Spec.RestartPolicy = v1.RestartPolicyNever
+	return job
+}
@@ -1,14 +1,57 @@
 package kopia
 
-// Backup does a backup of the given Path
-func (k *Kopia) Backup(backupPath string) error {
+import (
+	"fmt"
+	"time"
+)
+
+// BackupResult contains the outcome of a backup.
+type BackupResult struct {
+	SnapshotID    string        `json:"snapshotID"`
+	Source        string        `json:"source"`
+	Size          int64         `json:"size"`
+	Files         int           `json:"files"`
+	Dirs          int           `json:"dirs"`
+	Duration      time.Duration `json:"duration"`
+	FailedFiles   int           `json:"failedFiles"`
+	IgnoredErrors int           `json:"ignoredErrors"`
+	Errors        []EntryError  `json:"errors,omitempty"`
+}
+
+func newBackupResult(summary *Snapshot) *BackupResult {
+	return &BackupResult{
+		SnapshotID:    summary.ID,
+		Source:        summary.Source.String(),
+		Size:          summary.RootEntry.Summ.Size,
+		Files:         summary.RootEntry.Summ.Files,
+		Dirs:          summary.RootEntry.Summ.Dirs,
+		Duration:      summary.EndTime.Sub(summary.StartTime),
+		FailedFiles:   summary.RootEntry.Summ.NumFailed,
+		IgnoredErrors: summary.RootEntry.Summ.NumIgnoredErrors,
+		Errors:        summary.RootEntry.Summ.Errors,
+	}
+}
+
+// Backup does a backup of the given Path.
+// The result is also returned together with an error, if kopia reported one.
+func (k *Kopia) Backup(backupPath string) (*BackupResult, error) {
 	k.log.WithName("backup").V(1).Info("starting backup", "c", k.ctx)
 	k.log.WithName("backup").V(1).Info("repository config", "path", k.configPath)
 
-	return k.runKopiaCommand("backup", []string{
+	kc := k.newKopiaCommand("backup", []string{
 		"snapshot",
 		"create",
 		"--json",
 		backupPath,
 	})
+	err := k.execute("backup", &kc)
+
+	// Kopia also prints the summary if it exits non-zero because some files failed.
+	if kc.parser.summary == nil {
+		if err == nil {
+			err = fmt.Errorf("kopia did not report a backup summary")
+		}
+		return nil, err
+	}
+	return newBackupResult(kc.parser.summary), err
 }
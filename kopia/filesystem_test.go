@@ -0,0 +1,111 @@
+package kopia
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"github.com/go-logr/logr"
+)
+
+func TestFilesystemOptions(t *testing.T) {
+	storage := FilesystemOptions{Path: "/repository"}
+	if storage.Type() != StorageFilesystem {
+		t.Errorf("got type %q, want %q", storage.Type(), StorageFilesystem)
+	}
+	if want := []string{"--path", "/repository"}; !reflect.DeepEqual(storage.Args(), want) {
+		t.Errorf("got args %v, want %v", storage.Args(), want)
+	}
+	if want := (filesystemConfig{Path: "/repository"}); storage.Config() != want {
+		t.Errorf("got config %+v, want %+v", storage.Config(), want)
+	}
+	if err := storage.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	if err := (FilesystemOptions{}).Validate(); err == nil {
+		t.Error("expected an error without path")
+	}
+}
+
+// testContext returns a context with a logger that discards everything.
+func testContext() context.Context {
+	log := &atomic.Value{}
+	log.Store(logr.Discard())
+	return context.WithValue(context.Background(), logger.ContextKey{}, log)
+}
+
+// kopiaBinary returns the kopia binary from $KOPIA_BIN or $PATH and skips the test if there's none.
+func kopiaBinary(t *testing.T) string {
+	if bin := os.Getenv("KOPIA_BIN"); bin != "" {
+		return bin
+	}
+	bin, err := exec.LookPath("kopia")
+	if err != nil {
+		t.Skip("kopia binary not found, set KOPIA_BIN or add it to the PATH")
+	}
+	return bin
+}
+
+func TestFilesystemBackupAndRestore(t *testing.T) {
+	bin := kopiaBinary(t)
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	for name, content := range map[string]string{
+		"a.txt":        "hello",
+		"nested/b.txt": "kopia-k8s",
+	} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(source, name)), 0755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(source, name), []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage := FilesystemOptions{Path: filepath.Join(dir, "repository")}
+	newKopia := func(createIfMissing bool) (*Kopia, error) {
+		return New(testContext(), filepath.Join(dir, "config"), storage, "password", bin, "test", filepath.Join(dir, "cache"), createIfMissing)
+	}
+
+	_, err := newKopia(false)
+	if err == nil {
+		t.Fatal("expected an error when connecting to a missing repository")
+	}
+
+	k, err := newKopia(true)
+	if err != nil {
+		t.Fatalf("cannot create repository: %v", err)
+	}
+	result, err := k.Backup(source)
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if result.Files != 2 {
+		t.Errorf("backed up %d files, want 2", result.Files)
+	}
+
+	restored, err := k.Restore(RestoreOptions{
+		SnapshotID: LatestSnapshot,
+		SourceHost: "test",
+		SourcePath: source,
+		TargetPath: target,
+		Verify:     true,
+	})
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if !restored.Verified {
+		t.Error("restore wasn't verified")
+	}
+	content, err := os.ReadFile(filepath.Join(target, "nested", "b.txt"))
+	if err != nil || string(content) != "kopia-k8s" {
+		t.Errorf("restored file has content %q (%v), want %q", content, err, "kopia-k8s")
+	}
+}
@@ -1,30 +1,130 @@
 package kopia
 
-import "path"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
 
-func (k *Kopia) initRepo() {
-	log := k.log.WithName("initRepo")
+var (
+	// ErrRepositoryNotInitialized is returned if the storage doesn't contain a repository yet
+	// and it shouldn't get created.
+	ErrRepositoryNotInitialized = errors.New("repository is not initialized")
+	// ErrInvalidPassword is returned if the repository can't be opened with the encryption password.
+	ErrInvalidPassword = errors.New("invalid repository password")
+	// ErrBackendUnreachable is returned if kopia can't reach the storage backend,
+	// e.g. because the connection is refused, the hostname can't be resolved or a network timeout occurs.
+	// A canceled or timed out context of the caller isn't reported as unreachable backend.
+	ErrBackendUnreachable = errors.New("storage backend is unreachable")
+)
 
-	backupCommand := newCommand(k.ctx, log.WithName("kopia"), k.kopiaPath)
-	backupCommand.args = []string{
+// The messages kopia prints for the errors above.
+const (
+	kopiaNotInitializedMessage  = "repository not initialized in the provided storage"
+	kopiaInvalidPasswordMessage = "invalid repository password"
+	kopiaExistingDataMessage    = "found existing data in storage location"
+)
+
+// unreachableMessages are parts of the messages of Go's dial, DNS and network errors if the storage can't be reached.
+// "context deadline exceeded" isn't part of them, kopia also prints it if the caller's timeout expires.
+var unreachableMessages = []string{
+	"connection refused",
+	"connection reset by peer",
+	"no such host",
+	"network is unreachable",
+	"no route to host",
+	"i/o timeout",
+	"TLS handshake timeout",
+}
+
+// connectRepo connects to the repository in the storage.
+// kopia probes for the kopia.repository blob while connecting, if it's missing the repository
+// gets created if createIfMissing is set and ErrRepositoryNotInitialized is returned otherwise.
+func (k *Kopia) connectRepo(createIfMissing bool) error {
+	log := k.log.WithName("connectRepo")
+
+	output, err := k.runRepositoryCommand("connect")
+	if err == nil {
+		log.V(1).Info("connected to repository", "type", k.storage.Type())
+		return nil
+	}
+
+	if !strings.Contains(output, kopiaNotInitializedMessage) {
+		return k.repositoryError(err, output)
+	}
+	if !createIfMissing {
+		return fmt.Errorf("%s storage: %w", k.storage.Type(), ErrRepositoryNotInitialized)
+	}
+
+	log.Info("creating repository", "type", k.storage.Type())
+	output, err = k.runRepositoryCommand("create")
+	if err != nil && strings.Contains(output, kopiaExistingDataMessage) {
+		// Someone else created the repository in the meantime.
+		output, err = k.runRepositoryCommand("connect")
+	}
+	if err != nil {
+		return k.repositoryError(err, output)
+	}
+	return nil
+}
+
+// runRepositoryCommand runs `kopia repository <action> <type>` and returns what kopia printed to stderr.
+func (k *Kopia) runRepositoryCommand(action string) (string, error) {
+	repositoryCommand := newCommand(k.ctx, k.log.WithName(action+"Repo").WithName("kopia"), k.kopiaPath)
+	repositoryCommand.args = append([]string{
 		"repository",
-		"create",
-		"s3",
-		"--bucket",
-		k.bucket,
-		"--access-key",
-		k.accessKeyID,
-		"--secret-access-key",
-		k.secretAccessKey,
-		"--endpoint",
-		k.endpoint,
+		action,
+	}, k.storageArgs()...)
+	repositoryCommand.args = append(repositoryCommand.args,
 		"--config-file",
 		path.Join(k.configPath, "kopia.json"),
-		"--password",
-		k.encryptionPassword,
+		"--no-persist-credentials",
+	)
+	repositoryCommand.env = append(k.passwordEnv(), k.storage.Env()...)
+	stderr := &bytes.Buffer{}
+	repositoryCommand.stderr = stderr
+	err := repositoryCommand.run()
+	return stderr.String(), err
+}
+
+// storageArgs returns the storage type and its flags or, if the storage has to be read from a file, `from-config --file <file>`.
+func (k *Kopia) storageArgs() []string {
+	if storage, ok := k.storage.(configFileStorage); ok && storage.storageConfigFile() != "" {
+		return []string{"from-config", "--file", storage.storageConfigFile()}
 	}
-	err := backupCommand.run()
-	if err != nil {
-		log.Error(err, "error during repository creation")
+	return append([]string{k.storage.Type()}, k.storage.Args()...)
+}
+
+// repositoryError wraps the error of a failed connect or create into one of the typed errors.
+// Other errors, e.g. wrong credentials for the storage, are returned with the message of kopia.
+func (k *Kopia) repositoryError(err error, output string) error {
+	if k.ctx.Err() != nil {
+		return fmt.Errorf("%s storage: %w", k.storage.Type(), k.ctx.Err())
+	}
+	if strings.Contains(output, kopiaInvalidPasswordMessage) {
+		return fmt.Errorf("%s storage: %w", k.storage.Type(), ErrInvalidPassword)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%s storage: %w: %v", k.storage.Type(), ErrBackendUnreachable, err)
+	}
+	for _, message := range unreachableMessages {
+		if strings.Contains(output, message) {
+			return fmt.Errorf("%s storage: %w: %s", k.storage.Type(), ErrBackendUnreachable, lastLine(output, err))
+		}
+	}
+	return fmt.Errorf("%s storage: %s", k.storage.Type(), lastLine(output, err))
+}
+
+// lastLine returns the last line kopia printed, which usually contains the reason it failed.
+// It falls back to the error of the command if kopia didn't print anything.
+func lastLine(output string, err error) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return last
 	}
+	return err.Error()
 }
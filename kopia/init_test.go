@@ -0,0 +1,88 @@
+package kopia
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestRepositoryError(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := map[string]struct {
+		ctx     context.Context
+		err     error
+		output  string
+		wantErr error
+		wantMsg string
+	}{
+		"invalid password": {
+			output:  "ERROR error connecting to repository: invalid repository password\n",
+			wantErr: ErrInvalidPassword,
+			wantMsg: "s3 storage: invalid repository password",
+		},
+		"connection refused": {
+			output:  "ERROR error connecting to repository: dial tcp 127.0.0.1:9000: connect: connection refused\n",
+			wantErr: ErrBackendUnreachable,
+			wantMsg: "s3 storage: storage backend is unreachable: ERROR error connecting to repository: dial tcp 127.0.0.1:9000: connect: connection refused",
+		},
+		"unknown host": {
+			output:  "ERROR unable to connect: dial tcp: lookup minio.invalid: no such host\n",
+			wantErr: ErrBackendUnreachable,
+			wantMsg: "s3 storage: storage backend is unreachable: ERROR unable to connect: dial tcp: lookup minio.invalid: no such host",
+		},
+		"timeout": {
+			output:  "ERROR unable to connect: dial tcp 10.0.0.1:443: i/o timeout\n",
+			wantErr: ErrBackendUnreachable,
+			wantMsg: "s3 storage: storage backend is unreachable: ERROR unable to connect: dial tcp 10.0.0.1:443: i/o timeout",
+		},
+		"network error": {
+			err:     &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")},
+			wantErr: ErrBackendUnreachable,
+			wantMsg: "s3 storage: storage backend is unreachable: dial tcp: connect: connection refused",
+		},
+		"slow kopia command": {
+			output:  "ERROR error connecting to repository: context deadline exceeded\n",
+			wantMsg: "s3 storage: ERROR error connecting to repository: context deadline exceeded",
+		},
+		"canceled by the caller": {
+			ctx:     canceled,
+			output:  "ERROR unable to connect: dial tcp 10.0.0.1:443: i/o timeout\n",
+			wantErr: context.Canceled,
+			wantMsg: "s3 storage: context canceled",
+		},
+		"wrong credentials": {
+			output:  "Connecting to storage\nERROR error connecting to repository: The Access Key Id you provided does not exist in our records.\n",
+			wantMsg: "s3 storage: ERROR error connecting to repository: The Access Key Id you provided does not exist in our records.",
+		},
+		"no output": {
+			wantMsg: "s3 storage: exit status 1",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			k := &Kopia{ctx: tt.ctx, storage: S3Options{}}
+			if k.ctx == nil {
+				k.ctx = context.Background()
+			}
+			if tt.err == nil {
+				tt.err = exitErr
+			}
+			err := k.repositoryError(tt.err, tt.output)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != ErrBackendUnreachable && errors.Is(err, ErrBackendUnreachable) {
+				t.Errorf("got unreachable backend error %v", err)
+			}
+			if tt.wantErr == nil && (errors.Is(err, ErrBackendUnreachable) || errors.Is(err, ErrInvalidPassword)) {
+				t.Errorf("got typed error %v", err)
+			}
+			if err.Error() != tt.wantMsg {
+				t.Errorf("got message %q, want %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package kopia
 
 import (
+	"bytes"
 	"context"
 	"os"
 	"path"
@@ -14,10 +15,7 @@ type Kopia struct {
 	ctx                context.Context
 	log                logr.Logger
 	configPath         string
-	endpoint           string
-	bucket             string
-	accessKeyID        string
-	secretAccessKey    string
+	storage            Storage
 	encryptionPassword string
 	kopiaPath          string
 	LastExitCode       error
@@ -25,41 +23,71 @@ type Kopia struct {
 	cachePath          string
 }
 
-// New returns a new reference of kopia
-func New(ctx context.Context, configPath, accessKeyID, secretAccessKey, encryptionPassword, endpoint, bucket, kopiaPath, hostname, cachePath string) *Kopia {
+// New returns a new reference of kopia that is connected to the repository in the storage.
+// The repository is only created if createIfMissing is set.
+func New(ctx context.Context, configPath string, storage Storage, encryptionPassword, kopiaPath, hostname, cachePath string, createIfMissing bool) (*Kopia, error) {
 	k := &Kopia{
 		log:                logger.AppLogger(ctx),
 		ctx:                ctx,
 		configPath:         configPath,
-		accessKeyID:        accessKeyID,
-		secretAccessKey:    secretAccessKey,
+		storage:            storage,
 		encryptionPassword: encryptionPassword,
-		endpoint:           endpoint,
-		bucket:             bucket,
 		kopiaPath:          kopiaPath,
 		hostname:           hostname,
 		cachePath:          cachePath,
 	}
-	os.Mkdir(configPath, os.FileMode(0755))
-	k.initRepo()
+	logger.RegisterSecret(append(storage.Secrets(), encryptionPassword)...)
+	os.MkdirAll(configPath, os.FileMode(0755))
+	if writer, ok := storage.(fileWriter); ok {
+		var err error
+		k.storage, err = writer.writeFiles(configPath)
+		if err != nil {
+			k.log.Error(err, "could not write the credential files of the storage")
+		}
+	}
+	err := k.connectRepo(createIfMissing)
+	if err != nil {
+		return nil, err
+	}
 	k.writeConfigFile()
-	return k
+	return k, nil
 }
 
-func (k *Kopia) runKopiaCommand(name string, args []string) error {
-	log := k.log.WithName(name)
-
-	kc := newCommand(k.ctx, log.WithName("kopia"), k.kopiaPath)
+func (k *Kopia) newKopiaCommand(name string, args []string) command {
+	kc := newCommand(k.ctx, k.log.WithName(name).WithName("kopia"), k.kopiaPath)
 	kc.args = append([]string{
 		"--config-file",
 		path.Join(k.configPath, "kopia.json"),
-		"--password",
-		k.encryptionPassword,
 	}, args...)
+	kc.env = k.passwordEnv()
+	return kc
+}
+
+func (k *Kopia) runKopiaCommand(name string, args []string) error {
+	kc := k.newKopiaCommand(name, args)
+	return k.execute(name, &kc)
+}
+
+// runKopiaCommandWithOutput runs the given kopia command and returns everything
+// kopia printed to stdout. Stderr is still logged.
+func (k *Kopia) runKopiaCommandWithOutput(name string, args []string) ([]byte, error) {
+	kc := k.newKopiaCommand(name, args)
+	out := &bytes.Buffer{}
+	kc.stdout = out
+	err := k.execute(name, &kc)
+	return out.Bytes(), err
+}
+
+func (k *Kopia) execute(name string, kc *command) error {
 	err := kc.run()
 	if err != nil {
-		log.Error(err, "error during kopia execution")
+		k.log.WithName(name).Error(err, "error during kopia execution")
 		k.LastExitCode = err
 	}
 	return err
 }
+
+// passwordEnv returns the environment that passes the encryption password to kopia.
+func (k *Kopia) passwordEnv() []string {
+	return []string{"KOPIA_PASSWORD=" + k.encryptionPassword}
+}
@@ -2,6 +2,7 @@ package kopia
 
 import (
 	"context"
+	"io"
 	"os"
 	"os/exec"
 
@@ -10,10 +11,19 @@ import (
 )
 
 type command struct {
-	args      []string
+	args []string
+	// env is added to the environment of kopia.
+	// Secrets are passed this way, so that they don't show up in the process list.
+	env       []string
 	kopiaPath string
 	ctx       context.Context
 	log       logr.Logger
+	// stdout receives the raw stdout of kopia if set.
+	// Otherwise stdout is passed to the parser.
+	stdout io.Writer
+	// stderr additionally receives the raw stderr of kopia if set.
+	stderr io.Writer
+	parser *kopiaStdoutParser
 }
 
 func newCommand(ctx context.Context, log logr.Logger, kopiaPath string) command {
@@ -21,18 +31,23 @@ func newCommand(ctx context.Context, log logr.Logger, kopiaPath string) command
 		kopiaPath: kopiaPath,
 		ctx:       ctx,
 		log:       log,
+		parser:    &kopiaStdoutParser{log: log.WithName("stdout")},
 	}
 }
 
 func (k *command) run() error {
 	cmd := exec.CommandContext(k.ctx, k.kopiaPath, k.args...)
-	cmd.Env = os.Environ()
+	cmd.Env = append(os.Environ(), k.env...)
 
-	stdoutHandler := kopiaStdoutParser{log: k.log.WithName("stdout")}
-
-	cmd.Stdout = logger.New(stdoutHandler.parseKopiaStdout)
+	cmd.Stdout = logger.New(k.parser.parseKopiaStdout)
+	if k.stdout != nil {
+		cmd.Stdout = k.stdout
+	}
 
-	cmd.Stderr = logger.New(stdoutHandler.parseKopiaStdout)
+	cmd.Stderr = logger.New(k.parser.parseKopiaStdout)
+	if k.stderr != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, k.stderr)
+	}
 
 	err := cmd.Start()
 	if err != nil {
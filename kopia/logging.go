@@ -4,65 +4,50 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
-	"time"
+	"sync"
 	"unicode/utf8"
 
 	"github.com/go-logr/logr"
 )
 
-type backupSummary struct {
-	ID          string    `json:"id"`
-	Source      source    `json:"source"`
-	Description string    `json:"description"`
-	StartTime   time.Time `json:"startTime"`
-	EndTime     time.Time `json:"endTime"`
-	RootEntry   rootEntry `json:"rootEntry"`
-}
-type source struct {
-	Host     string `json:"host"`
-	UserName string `json:"userName"`
-	Path     string `json:"path"`
-}
-type errors struct {
-	Path  string `json:"path"`
-	Error string `json:"error"`
-}
-type summ struct {
-	Size             int64     `json:"size"`
-	Files            int       `json:"files"`
-	Symlinks         int       `json:"symlinks"`
-	Dirs             int       `json:"dirs"`
-	MaxTime          time.Time `json:"maxTime"`
-	NumFailed        int       `json:"numFailed"`
-	NumIgnoredErrors int       `json:"numIgnoredErrors"`
-	Errors           []errors  `json:"errors"`
-}
-type rootEntry struct {
-	Name  string    `json:"name"`
-	Type  string    `json:"type"`
-	Mode  string    `json:"mode"`
-	Mtime time.Time `json:"mtime"`
-	UID   int       `json:"uid"`
-	Gid   int       `json:"gid"`
-	Obj   string    `json:"obj"`
-	Summ  summ      `json:"summ"`
-}
-
 type kopiaStdoutParser struct {
-	log     logr.Logger
-	summary *backupSummary
+	log logr.Logger
+	// stdout and stderr are parsed concurrently
+	mutex        sync.Mutex
+	summary      *Snapshot
+	restoreStats *restoreStats
+	// maintenanceStats is only set if kopia deleted blobs.
+	maintenanceStats *maintenanceStats
+	verifyStats      verifyStats
 }
 
 func (k *kopiaStdoutParser) parseKopiaStdout(line string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
 	parsedLine := ""
-	k.summary = &backupSummary{}
+	summary := &Snapshot{}
 
 	// Kopia seems to print a carriage return if it's one of these
 	// status messages. This kills the output on some terminals.
 	if strings.Contains(line, "hashing") {
 		parsedLine = trimFirstRune(line)
-	} else if json.Unmarshal([]byte(line), k.summary) == nil { // check if the current line is the backup summary
-		parsedLine = fmt.Sprintf("backup finished with %d errors", k.summary.RootEntry.Summ.NumFailed)
+	} else if stats, ok := parseRestoreStats(line); ok {
+		k.restoreStats = stats
+		parsedLine = line
+	} else if stats, ok := parseMaintenanceStats(line); ok {
+		// Kopia deletes blobs in several tasks, so there can be more than one such line.
+		if k.maintenanceStats != nil {
+			stats.deletedBlobs += k.maintenanceStats.deletedBlobs
+			stats.reclaimedBytes += k.maintenanceStats.reclaimedBytes
+		}
+		k.maintenanceStats = stats
+		parsedLine = line
+	} else if k.verifyStats.add(line) {
+		parsedLine = line
+	} else if json.Unmarshal([]byte(line), summary) == nil && summary.ID != "" { // check if the current line is the backup summary
+		k.summary = summary
+		parsedLine = fmt.Sprintf("backup finished with %d errors", summary.RootEntry.Summ.NumFailed)
 	} else {
 		parsedLine = line
 	}
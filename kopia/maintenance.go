@@ -1,8 +1,44 @@
 package kopia
 
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// SafetyFull is kopia's default safety level for the maintenance.
+	SafetyFull = "full"
+	// SafetyNone disables the safety margins of the maintenance.
+	// Content is reclaimed faster, but it's only safe if nothing else writes to the repository.
+	SafetyNone = "none"
+)
+
+// MaintenanceOptions define how the maintenance runs.
+type MaintenanceOptions struct {
+	// Owner is set as maintenance owner of the repository.
+	Owner string
+	// Full forces a full maintenance.
+	// Otherwise it only runs if the last full maintenance is older than FullInterval.
+	Full bool
+	// QuickInterval and FullInterval are set on the repository if not zero.
+	QuickInterval time.Duration
+	FullInterval  time.Duration
+	// Safety is either SafetyFull or SafetyNone.
+	Safety string
+}
+
+// MaintenanceResult contains the outcome of a maintenance.
+type MaintenanceResult struct {
+	Full           bool          `json:"full"`
+	DeletedBlobs   int           `json:"deletedBlobs"`
+	ReclaimedBytes int64         `json:"reclaimedBytes"`
+	Duration       time.Duration `json:"duration"`
+}
+
 // setMaintenanceOwner sets the owner for the maintenance.
-// In kopia the maintenance owner for each repository has to be set.
-// As the amount of pods can change in k8s, we need to ensure that there's a maintenance owner for each run.
+// Kopia only runs the maintenance if the owner matches the user and host of the client.
 func (k *Kopia) setMaintenanceOwner(owner string) error {
 	k.log.WithName("maintenance_set_owner").V(1).Info("setting owner", "ownerName", owner)
 
@@ -14,33 +50,112 @@ func (k *Kopia) setMaintenanceOwner(owner string) error {
 	})
 }
 
-func (k *Kopia) enableQuickMaintenance() error {
-	k.log.V(1).WithName("maintenance_enable_quick").Info("enabling quick maintenance")
+// setMaintenanceCycles enables the quick and the full maintenance and sets their intervals.
+func (k *Kopia) setMaintenanceCycles(quickInterval, fullInterval time.Duration) error {
+	k.log.V(1).WithName("maintenance_set_cycles").Info("enabling maintenance", "quickInterval", quickInterval.String(), "fullInterval", fullInterval.String())
 
-	return k.runKopiaCommand("maintenance_enable_quick", []string{
+	args := []string{
 		"maintenance",
 		"set",
 		"--enable-quick",
 		"true",
-	})
+		"--enable-full",
+		"true",
+	}
+	if quickInterval > 0 {
+		args = append(args, "--quick-interval", quickInterval.String())
+	}
+	if fullInterval > 0 {
+		args = append(args, "--full-interval", fullInterval.String())
+	}
+	return k.runKopiaCommand("maintenance_set_cycles", args)
+}
+
+// fullMaintenanceDue returns true if the last successful full maintenance is older than the interval.
+// Without an interval, the one configured in the repository is used.
+func fullMaintenanceDue(info *MaintenanceInfo, interval time.Duration) bool {
+	if interval <= 0 {
+		interval = info.Params.FullCycle.Interval
+	}
+	return time.Since(info.Schedule.LastFullMaintenance()) >= interval
 }
 
 // RunMaintenance will run the maintenance for this kopia instance.
-// It will first set the maintenance owner, then enable quick maintenance and finally run it.
-func (k *Kopia) RunMaintenance(owner string) error {
-	err := k.setMaintenanceOwner(owner)
+// It will first take over the maintenance ownership if necessary, then enable the maintenance and finally run it.
+// The full maintenance runs if it's due or requested, otherwise the quick one.
+func (k *Kopia) RunMaintenance(opts MaintenanceOptions) (*MaintenanceResult, error) {
+	log := k.log.WithName("maintenance")
+
+	if opts.Safety != SafetyFull && opts.Safety != SafetyNone {
+		return nil, fmt.Errorf("unknown maintenance safety %q", opts.Safety)
+	}
+
+	info, err := k.GetMaintenanceInfo()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if info.Params.Owner != opts.Owner {
+		log.Info("taking over maintenance ownership", "previousOwner", info.Params.Owner, "owner", opts.Owner)
+		err = k.setMaintenanceOwner(opts.Owner)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = k.enableQuickMaintenance()
+	err = k.setMaintenanceCycles(opts.QuickInterval, opts.FullInterval)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	k.log.V(1).WithName("maintenance").Info("running maintenance")
-	return k.runKopiaCommand("maintenance", []string{
+	full := opts.Full || fullMaintenanceDue(info, opts.FullInterval)
+
+	args := []string{
 		"maintenance",
 		"run",
-	})
+		"--safety",
+		opts.Safety,
+	}
+	if full {
+		args = append(args, "--full")
+	}
+
+	log.V(1).Info("running maintenance", "full", full, "safety", opts.Safety)
+	start := time.Now()
+	kc := k.newKopiaCommand("maintenance", args)
+	err = k.execute("maintenance", &kc)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MaintenanceResult{
+		Full:     full,
+		Duration: time.Since(start),
+	}
+	if stats := kc.parser.maintenanceStats; stats != nil {
+		result.DeletedBlobs = stats.deletedBlobs
+		result.ReclaimedBytes = stats.reclaimedBytes
+	}
+	return result, nil
+}
+
+type maintenanceStats struct {
+	deletedBlobs   int
+	reclaimedBytes int64
+}
+
+// deletedBlobsRegex matches the line kopia prints after deleting unreferenced blobs, for example:
+// "Deleted total 12 unreferenced blobs (34.5 MB)"
+var deletedBlobsRegex = regexp.MustCompile(`Deleted total (\d+) unreferenced blobs \(([^)]+)\)`)
+
+func parseMaintenanceStats(line string) (*maintenanceStats, bool) {
+	match := deletedBlobsRegex.FindStringSubmatch(line)
+	if match == nil {
+		return nil, false
+	}
+
+	stats := &maintenanceStats{}
+	stats.deletedBlobs, _ = strconv.Atoi(match[1])
+	stats.reclaimedBytes = parseBytesString(match[2])
+	return stats, true
 }
@@ -0,0 +1,18 @@
+package kopia
+
+import "testing"
+
+func TestParseMaintenanceStats(t *testing.T) {
+	got, ok := parseMaintenanceStats("Deleted total 12 unreferenced blobs (34.5 MB)")
+	if !ok {
+		t.Fatal("expected the line to be parsed")
+	}
+	want := maintenanceStats{deletedBlobs: 12, reclaimedBytes: 34500000}
+	if *got != want {
+		t.Errorf("got %+v, want %+v", *got, want)
+	}
+
+	if _, ok := parseMaintenanceStats("Running full maintenance..."); ok {
+		t.Error("expected other lines to be ignored")
+	}
+}
@@ -6,6 +6,10 @@ import (
 	"path/filepath"
 )
 
+// Username is the user kopia-k8s connects to the repository as.
+// Together with the hostname it identifies the client, e.g. for the maintenance ownership.
+const Username = "kopia-k8s"
+
 type repository struct {
 	Storage                 storage `json:"storage"`
 	Caching                 caching `json:"caching"`
@@ -15,16 +19,9 @@ type repository struct {
 	EnableActions           bool    `json:"enableActions"`
 	FormatBlobCacheDuration int64   `json:"formatBlobCacheDuration"`
 }
-type config struct {
-	Bucket          string `json:"bucket"`
-	Endpoint        string `json:"endpoint"`
-	AccessKeyID     string `json:"accessKeyID"`
-	SecretAccessKey string `json:"secretAccessKey"`
-	SessionToken    string `json:"sessionToken"`
-}
 type storage struct {
-	Type   string `json:"type"`
-	Config config `json:"config"`
+	Type   string      `json:"type"`
+	Config interface{} `json:"config"`
 }
 type caching struct {
 	CacheDirectory       string `json:"cacheDirectory"`
@@ -36,13 +33,8 @@ type caching struct {
 func (k *Kopia) newRepositoryConfigFile() repository {
 	return repository{
 		Storage: storage{
-			Type: "s3",
-			Config: config{
-				Bucket:          k.bucket,
-				Endpoint:        k.endpoint,
-				AccessKeyID:     k.accessKeyID,
-				SecretAccessKey: k.secretAccessKey,
-			},
+			Type:   k.storage.Type(),
+			Config: k.storage.Config(),
 		},
 		Caching: caching{
 			CacheDirectory:       k.cachePath,
@@ -51,8 +43,8 @@ func (k *Kopia) newRepositoryConfigFile() repository {
 			MaxListCacheDuration: 30,
 		},
 		Hostname:                k.hostname,
-		Username:                "kopia-k8s",
-		Description:             "Repository in S3: restic.earthnet.ch kopia",
+		Username:                Username,
+		Description:             "kopia-k8s repository in " + k.storage.Type(),
 		EnableActions:           false,
 		FormatBlobCacheDuration: 900000000000,
 	}
@@ -0,0 +1,239 @@
+package kopia
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LatestSnapshot can be passed as snapshot ID to restore the most recent snapshot of a source.
+const LatestSnapshot = "latest"
+
+// RestoreOptions defines what should be restored and how.
+type RestoreOptions struct {
+	// SnapshotID is either a kopia snapshot ID or LatestSnapshot.
+	SnapshotID string
+	// SourceHost and SourcePath select the source if SnapshotID is LatestSnapshot.
+	SourceHost string
+	SourcePath string
+	// TargetPath is the directory the snapshot gets restored into.
+	TargetPath string
+	// Overwrite replaces existing files, directories and symlinks in the target.
+	Overwrite bool
+	// SkipExisting leaves files that already exist in the target untouched.
+	SkipExisting bool
+	// DryRun only resolves the snapshot and reports what would get restored.
+	DryRun bool
+	// Verify compares the number and size of the files in the target with the snapshot after the restore.
+	// It's only meaningful if the target was empty, so it's skipped if files already existed in the target.
+	Verify bool
+}
+
+// RestoreResult contains the outcome of a restore.
+type RestoreResult struct {
+	SnapshotID   string        `json:"snapshotID"`
+	Source       string        `json:"source"`
+	Files        int           `json:"files"`
+	Dirs         int           `json:"dirs"`
+	Symlinks     int           `json:"symlinks"`
+	Bytes        int64         `json:"bytes"`
+	SkippedFiles int           `json:"skippedFiles"`
+	SkippedBytes int64         `json:"skippedBytes"`
+	Duration     time.Duration `json:"duration"`
+	DryRun       bool          `json:"dryRun"`
+	Verified     bool          `json:"verified"`
+	// FailedEntries is the number of entries kopia couldn't read while taking the snapshot.
+	// They aren't part of the snapshot, so they can't be restored.
+	FailedEntries int `json:"failedEntries"`
+}
+
+// Restore restores a snapshot into the target path.
+func (k *Kopia) Restore(opts RestoreOptions) (*RestoreResult, error) {
+	log := k.log.WithName("restore")
+
+	if opts.Overwrite && opts.SkipExisting {
+		return nil, fmt.Errorf("overwrite and skip-existing are mutually exclusive")
+	}
+	if opts.TargetPath == "" {
+		return nil, fmt.Errorf("no restore target given")
+	}
+
+	snapshot, err := k.findSnapshot(opts.SnapshotID, opts.SourceHost, opts.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("restoring snapshot", "id", snapshot.ID, "source", snapshot.Source.String(), "startTime", snapshot.StartTime, "target", opts.TargetPath)
+	if failed := snapshot.RootEntry.Summ.NumFailed; failed > 0 {
+		log.Info("snapshot is incomplete, the entries that failed during the backup can't be restored", "failedEntries", failed, "errors", snapshot.RootEntry.Summ.Errors)
+	}
+
+	if opts.DryRun {
+		result := &RestoreResult{
+			SnapshotID:    snapshot.ID,
+			Source:        snapshot.Source.String(),
+			Files:         snapshot.RootEntry.Summ.Files,
+			Dirs:          snapshot.RootEntry.Summ.Dirs,
+			Symlinks:      snapshot.RootEntry.Summ.Symlinks,
+			Bytes:         snapshot.RootEntry.Summ.Size,
+			DryRun:        true,
+			FailedEntries: snapshot.RootEntry.Summ.NumFailed,
+		}
+		log.Info("dry run, nothing restored", "files", result.Files, "dirs", result.Dirs, "bytes", result.Bytes)
+		return result, nil
+	}
+
+	args := []string{
+		"snapshot",
+		"restore",
+		snapshot.ID,
+		opts.TargetPath,
+	}
+	if opts.Overwrite {
+		args = append(args, "--overwrite-files", "--overwrite-directories", "--overwrite-symlinks")
+	} else {
+		args = append(args, "--no-overwrite-files", "--no-overwrite-symlinks")
+	}
+	if opts.SkipExisting {
+		args = append(args, "--skip-existing")
+	}
+
+	start := time.Now()
+	kc := k.newKopiaCommand("restore", args)
+	err = k.execute("restore", &kc)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RestoreResult{
+		SnapshotID:    snapshot.ID,
+		Source:        snapshot.Source.String(),
+		Duration:      time.Since(start),
+		FailedEntries: snapshot.RootEntry.Summ.NumFailed,
+	}
+	if stats := kc.parser.restoreStats; stats != nil {
+		result.Files = stats.files
+		result.Dirs = stats.dirs
+		result.Symlinks = stats.symlinks
+		result.Bytes = stats.bytes
+		result.SkippedFiles = stats.skippedFiles
+		result.SkippedBytes = stats.skippedBytes
+	} else {
+		log.Info("kopia did not report restore statistics")
+	}
+
+	log.Info("restore finished",
+		"id", result.SnapshotID,
+		"files", result.Files,
+		"dirs", result.Dirs,
+		"bytes", result.Bytes,
+		"skippedFiles", result.SkippedFiles,
+		"failedEntries", result.FailedEntries,
+		"duration", result.Duration.String())
+
+	if opts.Verify && result.SkippedFiles > 0 {
+		// The skipped files were already in the target, their content isn't the one of the snapshot.
+		log.Info("not verifying the restore, files already existed in the target", "skippedFiles", result.SkippedFiles)
+	} else if opts.Verify {
+		err = verifyRestore(opts.TargetPath, snapshot.RootEntry.Summ)
+		if err != nil {
+			return result, err
+		}
+		result.Verified = true
+		log.Info("restore verified", "files", snapshot.RootEntry.Summ.Files, "bytes", snapshot.RootEntry.Summ.Size)
+	}
+	return result, nil
+}
+
+// verifyRestore counts the regular files in the target and compares their number and size with the summary of the snapshot.
+// The summary only counts the files that made it into the snapshot, entries that failed during the backup aren't included.
+func verifyRestore(target string, summary DirectorySummary) error {
+	files := 0
+	size := int64(0)
+	err := filepath.WalkDir(target, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files++
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot verify restore: %w", err)
+	}
+
+	if files != summary.Files || size != summary.Size {
+		return fmt.Errorf("restore doesn't match the snapshot: restored %d files with %d bytes, snapshot contains %d files with %d bytes",
+			files, size, summary.Files, summary.Size)
+	}
+	return nil
+}
+
+type restoreStats struct {
+	files        int
+	dirs         int
+	symlinks     int
+	bytes        int64
+	skippedFiles int
+	skippedBytes int64
+}
+
+// restoreStatsRegex matches the summary kopia prints after a restore, for example:
+// "Restored 5 files, 2 directories and 0 symbolic links (1.2 MB), skipped 1 (12 B)."
+var restoreStatsRegex = regexp.MustCompile(`Restored (\d+) files, (\d+) directories and (\d+) symbolic links \(([^)]+)\)(?:, skipped (\d+) \(([^)]+)\))?`)
+
+func parseRestoreStats(line string) (*restoreStats, bool) {
+	match := restoreStatsRegex.FindStringSubmatch(line)
+	if match == nil {
+		return nil, false
+	}
+
+	stats := &restoreStats{}
+	stats.files, _ = strconv.Atoi(match[1])
+	stats.dirs, _ = strconv.Atoi(match[2])
+	stats.symlinks, _ = strconv.Atoi(match[3])
+	stats.bytes = parseBytesString(match[4])
+	if match[5] != "" {
+		stats.skippedFiles, _ = strconv.Atoi(match[5])
+		stats.skippedBytes = parseBytesString(match[6])
+	}
+	return stats, true
+}
+
+var byteUnits = map[string]float64{
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"PB":  1e15,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+	"PIB": 1 << 50,
+}
+
+// parseBytesString converts the human readable sizes kopia prints (e.g. "1.2 MB") back to bytes.
+// As kopia rounds these values, the result is an approximation.
+func parseBytesString(s string) int64 {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return int64(value * byteUnits[strings.ToUpper(fields[1])])
+}
@@ -0,0 +1,106 @@
+package kopia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRestoreStats(t *testing.T) {
+	tests := map[string]struct {
+		line   string
+		want   restoreStats
+		wantOK bool
+	}{
+		"without skipped files": {
+			line:   "Restored 5 files, 2 directories and 1 symbolic links (1.5 KB).",
+			want:   restoreStats{files: 5, dirs: 2, symlinks: 1, bytes: 1500},
+			wantOK: true,
+		},
+		"with skipped files": {
+			line:   "Restored 5 files, 2 directories and 0 symbolic links (1.2 MB), skipped 1 (12 B).",
+			want:   restoreStats{files: 5, dirs: 2, bytes: 1200000, skippedFiles: 1, skippedBytes: 12},
+			wantOK: true,
+		},
+		"other line": {
+			line: "Processed 12 (1.5 KB) of 12 (1.5 KB) 100%",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := parseRestoreStats(tt.line)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if ok && *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBytesString(t *testing.T) {
+	tests := map[string]int64{
+		"12 B":     12,
+		"1.5 KB":   1500,
+		"2 MiB":    2 << 20,
+		"1.25 GB":  1250000000,
+		"3 tib":    3 << 40,
+		"":         0,
+		"12":       0,
+		"abc B":    0,
+		"1 2 3 KB": 0,
+	}
+	for input, want := range tests {
+		if got := parseBytesString(input); got != want {
+			t.Errorf("parseBytesString(%q) = %d, want %d", input, got, want)
+		}
+	}
+}
+
+func TestVerifyRestore(t *testing.T) {
+	target := t.TempDir()
+	for name, content := range map[string]string{
+		"a.txt":        "hello",
+		"nested/b.txt": "kopia-k8s",
+	} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(target, name)), 0755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(target, name), []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(target, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		summary DirectorySummary
+		wantErr bool
+	}{
+		"matches": {
+			summary: DirectorySummary{Files: 2, Size: 14, Symlinks: 1, Dirs: 2},
+		},
+		"failed entries aren't part of the summary": {
+			summary: DirectorySummary{Files: 2, Size: 14, NumFailed: 3},
+		},
+		"missing file": {
+			summary: DirectorySummary{Files: 3, Size: 20},
+			wantErr: true,
+		},
+		"different size": {
+			summary: DirectorySummary{Files: 2, Size: 15},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := verifyRestore(target, tt.summary)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package kopia
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RetentionPolicy defines how many snapshots kopia keeps for each time frame.
+// Nil values leave the current setting of kopia's policy untouched, zero keeps no snapshots for the time frame.
+type RetentionPolicy struct {
+	KeepLatest  *int
+	KeepHourly  *int
+	KeepDaily   *int
+	KeepWeekly  *int
+	KeepMonthly *int
+	KeepAnnual  *int
+}
+
+func (p *RetentionPolicy) fields() map[string]**int {
+	return map[string]**int{
+		"keep-latest":  &p.KeepLatest,
+		"keep-hourly":  &p.KeepHourly,
+		"keep-daily":   &p.KeepDaily,
+		"keep-weekly":  &p.KeepWeekly,
+		"keep-monthly": &p.KeepMonthly,
+		"keep-annual":  &p.KeepAnnual,
+	}
+}
+
+// retentionFlagOrder keeps the generated flags stable.
+var retentionFlagOrder = []string{"keep-latest", "keep-hourly", "keep-daily", "keep-weekly", "keep-monthly", "keep-annual"}
+
+// IsEmpty returns true if the policy doesn't set anything.
+func (p RetentionPolicy) IsEmpty() bool {
+	return len(p.Flags()) == 0
+}
+
+// Flags returns the policy as command line flags.
+// Kopia and kopia-k8s use the same flag names, so they can be passed to either.
+func (p RetentionPolicy) Flags() []string {
+	flags := []string{}
+	fields := p.fields()
+	for _, name := range retentionFlagOrder {
+		if value := *fields[name]; value != nil {
+			flags = append(flags, "--"+name, strconv.Itoa(*value))
+		}
+	}
+	return flags
+}
+
+// ParseRetentionPolicy parses a policy in the form "keep-daily=7,keep-weekly=4".
+// Every value that isn't set in the string is taken from base.
+// The values must not be negative, zero turns off a time frame that's set in base.
+func ParseRetentionPolicy(s string, base RetentionPolicy) (RetentionPolicy, error) {
+	policy := base
+	fields := policy.fields()
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return base, fmt.Errorf("invalid retention %q, expected <key>=<value>", pair)
+		}
+		field, ok := fields[strings.TrimSpace(kv[0])]
+		if !ok {
+			return base, fmt.Errorf("unknown retention %q", kv[0])
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return base, fmt.Errorf("invalid retention value %q for %s", kv[1], kv[0])
+		}
+		if value < 0 {
+			return base, fmt.Errorf("retention value for %s must not be negative, got %d", kv[0], value)
+		}
+		*field = &value
+	}
+	return policy, nil
+}
+
+// SetRetentionPolicy sets the retention of the policy for the given path on this host.
+func (k *Kopia) SetRetentionPolicy(path string, policy RetentionPolicy) error {
+	k.log.WithName("policy_set").V(1).Info("setting retention policy", "path", path, "policy", policy.Flags())
+
+	return k.runKopiaCommand("policy_set", append([]string{
+		"policy",
+		"set",
+		path,
+	}, policy.Flags()...))
+}
@@ -0,0 +1,105 @@
+package kopia
+
+import (
+	"reflect"
+	"testing"
+)
+
+// keep returns a pointer to the given retention value.
+func keep(value int) *int {
+	return &value
+}
+
+func TestParseRetentionPolicy(t *testing.T) {
+	base := RetentionPolicy{KeepLatest: keep(10), KeepHourly: keep(24)}
+
+	tests := map[string]struct {
+		input   string
+		want    RetentionPolicy
+		wantErr bool
+	}{
+		"empty keeps base": {
+			input: "",
+			want:  base,
+		},
+		"overrides and adds values": {
+			input: "keep-hourly=12, keep-daily=7",
+			want:  RetentionPolicy{KeepLatest: keep(10), KeepHourly: keep(12), KeepDaily: keep(7)},
+		},
+		"ignores empty pairs": {
+			input: "keep-weekly=4,,",
+			want:  RetentionPolicy{KeepLatest: keep(10), KeepHourly: keep(24), KeepWeekly: keep(4)},
+		},
+		"zero turns off a value of the base": {
+			input: "keep-hourly=0",
+			want:  RetentionPolicy{KeepLatest: keep(10), KeepHourly: keep(0)},
+		},
+		"rejects negative values": {
+			input:   "keep-daily=-1",
+			wantErr: true,
+		},
+		"rejects unknown keys": {
+			input:   "keep-forever=1",
+			wantErr: true,
+		},
+		"rejects missing values": {
+			input:   "keep-daily",
+			wantErr: true,
+		},
+		"rejects non-numeric values": {
+			input:   "keep-daily=seven",
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseRetentionPolicy(tt.input, base)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got policy %+v", got)
+				}
+				if !reflect.DeepEqual(got, base) {
+					t.Errorf("expected the base policy on error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionPolicyFlags(t *testing.T) {
+	tests := map[string]struct {
+		policy RetentionPolicy
+		want   []string
+	}{
+		"empty": {
+			policy: RetentionPolicy{},
+			want:   []string{},
+		},
+		"stable order": {
+			policy: RetentionPolicy{KeepAnnual: keep(2), KeepLatest: keep(5), KeepDaily: keep(7)},
+			want:   []string{"--keep-latest", "5", "--keep-daily", "7", "--keep-annual", "2"},
+		},
+		"zero is passed on": {
+			policy: RetentionPolicy{KeepLatest: keep(5), KeepHourly: keep(0)},
+			want:   []string{"--keep-latest", "5", "--keep-hourly", "0"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := tt.policy.Flags()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if tt.policy.IsEmpty() != (len(tt.want) == 0) {
+				t.Errorf("IsEmpty() = %v for flags %v", tt.policy.IsEmpty(), got)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package kopia
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSFTPOptions(t *testing.T) {
+	storage := SFTPOptions{
+		Host:           "backup.example.com",
+		Port:           2222,
+		Path:           "/srv/kopia",
+		Username:       "kopia",
+		KeyFile:        "/etc/kopia/sftp-key",
+		KnownHostsFile: "/etc/kopia/known_hosts",
+	}
+	want := []string{
+		"--host", "backup.example.com",
+		"--port", "2222",
+		"--path", "/srv/kopia",
+		"--username", "kopia",
+		"--known-hosts", "/etc/kopia/known_hosts",
+		"--keyfile", "/etc/kopia/sftp-key",
+	}
+	if !reflect.DeepEqual(storage.Args(), want) {
+		t.Errorf("got args %v, want %v", storage.Args(), want)
+	}
+	if err := storage.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+
+	storage.KeyFile = ""
+	if err := storage.Validate(); err == nil {
+		t.Error("expected an error without key and password")
+	}
+	storage.Password = "secret-password"
+	if err := storage.Validate(); err != nil {
+		t.Errorf("unexpected validation error with a password: %v", err)
+	}
+	for _, arg := range storage.Args() {
+		if strings.Contains(arg, storage.Password) {
+			t.Errorf("password is part of the args %v", storage.Args())
+		}
+	}
+}
+
+// sftpPassword is the password of the user on the sftpServer.
+const sftpPassword = "secret-password"
+
+// sftpServer is an in-process SFTP server that serves the local filesystem to a single user with a single key or sftpPassword.
+type sftpServer struct {
+	listener  net.Listener
+	config    *ssh.ServerConfig
+	hostKey   ssh.PublicKey
+	clientKey string
+}
+
+func newSFTPServer(t *testing.T) *sftpServer {
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPublic, clientPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSSHPublic, err := ssh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(clientPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "kopia" && reflect.DeepEqual(key.Marshal(), clientSSHPublic.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "kopia" && string(password) == sftpPassword {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &sftpServer{
+		listener:  listener,
+		config:    config,
+		hostKey:   hostSigner.PublicKey(),
+		clientKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *sftpServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *sftpServer) handle(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range channelRequests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				if server, err := sftp.NewServer(channel); err == nil {
+					_ = server.Serve()
+					_ = server.Close()
+				}
+				channel.Close()
+			}
+		}()
+	}
+}
+
+// options returns the options to log in to the server, with the key and the known hosts as data.
+func (s *sftpServer) options(path string) SFTPOptions {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return SFTPOptions{
+		Host:           addr.IP.String(),
+		Port:           addr.Port,
+		Path:           path,
+		Username:       "kopia",
+		KeyData:        s.clientKey,
+		KnownHostsData: knownhosts.Line([]string{knownhosts.Normalize(addr.String())}, s.hostKey) + "\n",
+	}
+}
+
+// TestSFTPWrittenFiles logs in to the server with the files written for kopia, the same way kopia does.
+func TestSFTPWrittenFiles(t *testing.T) {
+	server := newSFTPServer(t)
+	dir := t.TempDir()
+	storage, err := server.options(filepath.Join(dir, "repository")).writeFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := storage.(SFTPOptions)
+	if err := options.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(options.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key file has mode %v, want 0600", info.Mode().Perm())
+	}
+
+	key, err := os.ReadFile(options.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKeyCallback, err := knownhosts.New(options.KnownHostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ssh.Dial("tcp", server.listener.Addr().String(), &ssh.ClientConfig{
+		User:            options.Username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		t.Fatalf("cannot log in with the written files: %v", err)
+	}
+	defer conn.Close()
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.MkdirAll(options.Path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(options.Path); err != nil {
+		t.Errorf("repository directory wasn't created: %v", err)
+	}
+
+	other := newSFTPServer(t)
+	_, err = ssh.Dial("tcp", other.listener.Addr().String(), &ssh.ClientConfig{
+		User:            options.Username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err == nil {
+		t.Error("expected an unknown host to be rejected")
+	}
+}
+
+// TestSFTPPasswordFile logs in to the server with the password from the storage config file kopia reads it from.
+func TestSFTPPasswordFile(t *testing.T) {
+	server := newSFTPServer(t)
+	dir := t.TempDir()
+	options := server.options(filepath.Join(dir, "repository"))
+	options.KeyData = ""
+	options.Password = sftpPassword
+	storage, err := options.writeFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := &Kopia{storage: storage}
+	file := storage.(configFileStorage).storageConfigFile()
+	want := []string{"from-config", "--file", file}
+	if !reflect.DeepEqual(k.storageArgs(), want) {
+		t.Errorf("got storage args %v, want %v", k.storageArgs(), want)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("storage config file has mode %v, want 0600", info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := struct {
+		Storage struct {
+			Type   string     `json:"type"`
+			Config sftpConfig `json:"config"`
+		} `json:"storage"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Storage.Type != StorageSFTP {
+		t.Errorf("got storage type %q, want %q", config.Storage.Type, StorageSFTP)
+	}
+	hostKeyCallback, err := knownhosts.New(config.Storage.Config.KnownHostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(config.Storage.Config.Host, strconv.Itoa(config.Storage.Config.Port)), &ssh.ClientConfig{
+		User:            config.Storage.Config.Username,
+		Auth:            []ssh.AuthMethod{ssh.Password(config.Storage.Config.Password)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		t.Fatalf("cannot log in with the storage config file: %v", err)
+	}
+	conn.Close()
+
+	keyed, err := server.options(filepath.Join(dir, "repository")).writeFiles(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file := keyed.(configFileStorage).storageConfigFile(); file != "" {
+		t.Errorf("got storage config file %q with a key, want none", file)
+	}
+}
+
+func TestSFTPBackup(t *testing.T) {
+	bin := kopiaBinary(t)
+	server := newSFTPServer(t)
+	tests := map[string]func(SFTPOptions) SFTPOptions{
+		"key": func(options SFTPOptions) SFTPOptions {
+			return options
+		},
+		"password": func(options SFTPOptions) SFTPOptions {
+			options.KeyData = ""
+			options.Password = sftpPassword
+			return options
+		},
+	}
+	for name, auth := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "source")
+			if err := os.MkdirAll(source, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(source, "a.txt"), []byte("hello"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			k, err := New(testContext(), filepath.Join(dir, "config"), auth(server.options(filepath.Join(dir, "repository"))), "password", bin, "test", filepath.Join(dir, "cache"), true)
+			if err != nil {
+				t.Fatalf("cannot create repository: %v", err)
+			}
+			result, err := k.Backup(source)
+			if err != nil {
+				t.Fatalf("backup failed: %v", err)
+			}
+			if result.Files != 1 {
+				t.Errorf("backed up %d files, want 1", result.Files)
+			}
+		})
+	}
+}
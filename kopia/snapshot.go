@@ -0,0 +1,127 @@
+package kopia
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Snapshot is a snapshot as kopia reports it with `--json`.
+type Snapshot struct {
+	ID              string    `json:"id"`
+	Source          Source    `json:"source"`
+	Description     string    `json:"description"`
+	StartTime       time.Time `json:"startTime"`
+	EndTime         time.Time `json:"endTime"`
+	RootEntry       RootEntry `json:"rootEntry"`
+	RetentionReason []string  `json:"retentionReason,omitempty"`
+}
+
+// Source identifies what got backed up.
+// Kopia-k8s uses the namespace as host and /data/<pvcname> as path.
+type Source struct {
+	Host     string `json:"host"`
+	UserName string `json:"userName"`
+	Path     string `json:"path"`
+}
+
+// EntryError is an error kopia encountered for a single file.
+type EntryError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// DirectorySummary contains the aggregated statistics of a directory.
+type DirectorySummary struct {
+	Size             int64        `json:"size"`
+	Files            int          `json:"files"`
+	Symlinks         int          `json:"symlinks"`
+	Dirs             int          `json:"dirs"`
+	MaxTime          time.Time    `json:"maxTime"`
+	NumFailed        int          `json:"numFailed"`
+	NumIgnoredErrors int          `json:"numIgnoredErrors"`
+	Errors           []EntryError `json:"errors"`
+}
+
+// RootEntry is the root directory of a snapshot.
+type RootEntry struct {
+	Name  string           `json:"name"`
+	Type  string           `json:"type"`
+	Mode  string           `json:"mode"`
+	Mtime time.Time        `json:"mtime"`
+	UID   int              `json:"uid"`
+	Gid   int              `json:"gid"`
+	Obj   string           `json:"obj"`
+	Summ  DirectorySummary `json:"summ"`
+}
+
+func (s Source) String() string {
+	return fmt.Sprintf("%s@%s:%s", s.UserName, s.Host, s.Path)
+}
+
+// ListSnapshots returns the snapshots in the repository, sorted by their start time.
+// Empty host or path match all snapshots.
+func (k *Kopia) ListSnapshots(host, path string) ([]Snapshot, error) {
+	out, err := k.runKopiaCommandWithOutput("snapshot_list", []string{
+		"snapshot",
+		"list",
+		"--all",
+		"--json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	err = json.Unmarshal(out, &snapshots)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse snapshot list: %w", err)
+	}
+
+	matching := []Snapshot{}
+	for _, snapshot := range snapshots {
+		if host != "" && snapshot.Source.Host != host {
+			continue
+		}
+		if path != "" && snapshot.Source.Path != path {
+			continue
+		}
+		matching = append(matching, snapshot)
+	}
+
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].StartTime.Before(matching[j].StartTime)
+	})
+	return matching, nil
+}
+
+// findSnapshot returns the snapshot with the given ID.
+// If the ID is LatestSnapshot, it returns the most recent snapshot of the given host and path.
+func (k *Kopia) findSnapshot(id, host, path string) (*Snapshot, error) {
+	if id != LatestSnapshot {
+		snapshots, err := k.ListSnapshots("", "")
+		if err != nil {
+			return nil, err
+		}
+		for i := range snapshots {
+			if snapshots[i].ID == id {
+				return &snapshots[i], nil
+			}
+		}
+		return nil, fmt.Errorf("snapshot %s not found", id)
+	}
+
+	if host == "" || path == "" {
+		return nil, fmt.Errorf("source host and path are required to find the latest snapshot")
+	}
+
+	snapshots, err := k.ListSnapshots(host, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("no snapshot found for host %s and path %s", host, path)
+	}
+	return &snapshots[len(snapshots)-1], nil
+}
@@ -0,0 +1,183 @@
+package kopia
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Status summarizes the format of the repository, its size and the state of the maintenance.
+type Status struct {
+	FormatVersion int    `json:"formatVersion"`
+	Encryption    string `json:"encryption"`
+	Hash          string `json:"hash"`
+	// BlobCount and TotalSize are only set if the blobs were listed, see AddBlobStats.
+	BlobCount            *int      `json:"blobCount,omitempty"`
+	TotalSize            *int64    `json:"totalSize,omitempty"`
+	MaintenanceOwner     string    `json:"maintenanceOwner"`
+	LastQuickMaintenance time.Time `json:"lastQuickMaintenance"`
+	LastFullMaintenance  time.Time `json:"lastFullMaintenance"`
+	NextQuickMaintenance time.Time `json:"nextQuickMaintenance"`
+	NextFullMaintenance  time.Time `json:"nextFullMaintenance"`
+}
+
+// repositoryStatus is the part of `kopia repository status --json` kopia-k8s uses.
+type repositoryStatus struct {
+	ContentFormat struct {
+		Hash       string `json:"hash"`
+		Encryption string `json:"encryption"`
+		Version    int    `json:"version"`
+	} `json:"contentFormat"`
+}
+
+// blob is an entry of `kopia blob list --json`.
+type blob struct {
+	ID     string `json:"id"`
+	Length int64  `json:"length"`
+}
+
+// MaintenanceInfo is the output of `kopia maintenance info --json`.
+type MaintenanceInfo struct {
+	Params   MaintenanceParams   `json:"params"`
+	Schedule MaintenanceSchedule `json:"schedule"`
+}
+
+// MaintenanceParams are the maintenance settings of the repository.
+type MaintenanceParams struct {
+	Owner      string           `json:"owner"`
+	QuickCycle MaintenanceCycle `json:"quick"`
+	FullCycle  MaintenanceCycle `json:"full"`
+}
+
+// MaintenanceCycle configures the quick or the full maintenance.
+type MaintenanceCycle struct {
+	Enabled  bool          `json:"enabled"`
+	Interval time.Duration `json:"interval"`
+}
+
+// MaintenanceSchedule contains when the maintenance is due and the past runs of each task.
+type MaintenanceSchedule struct {
+	NextFullMaintenance  time.Time                       `json:"nextFullMaintenance"`
+	NextQuickMaintenance time.Time                       `json:"nextQuickMaintenance"`
+	Runs                 map[string][]MaintenanceTaskRun `json:"runs"`
+}
+
+// MaintenanceTaskRun is a single run of a maintenance task.
+type MaintenanceTaskRun struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Success bool      `json:"success,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// lastSuccess returns the end of the most recent successful run of the tasks matching the filter.
+func (s MaintenanceSchedule) lastSuccess(match func(task string) bool) time.Time {
+	last := time.Time{}
+	for task, runs := range s.Runs {
+		if !match(task) {
+			continue
+		}
+		for _, run := range runs {
+			if run.Success && run.End.After(last) {
+				last = run.End
+			}
+		}
+	}
+	return last
+}
+
+// LastQuickMaintenance returns when the last quick maintenance finished successfully.
+func (s MaintenanceSchedule) LastQuickMaintenance() time.Time {
+	return s.lastSuccess(func(task string) bool {
+		return strings.HasPrefix(task, "quick-") || task == "index-compaction"
+	})
+}
+
+// LastFullMaintenance returns when the last full maintenance finished successfully.
+func (s MaintenanceSchedule) LastFullMaintenance() time.Time {
+	return s.lastSuccess(func(task string) bool {
+		return strings.HasPrefix(task, "full-") || task == "snapshot-gc"
+	})
+}
+
+// GetMaintenanceInfo returns the maintenance settings and history of the repository.
+func (k *Kopia) GetMaintenanceInfo() (*MaintenanceInfo, error) {
+	out, err := k.runKopiaCommandWithOutput("maintenance_info", []string{
+		"maintenance",
+		"info",
+		"--json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := &MaintenanceInfo{}
+	err = json.Unmarshal(out, info)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse maintenance info: %w", err)
+	}
+	return info, nil
+}
+
+// GetStatus returns the status of the repository and its maintenance without the blob stats.
+func (k *Kopia) GetStatus() (*Status, error) {
+	out, err := k.runKopiaCommandWithOutput("repository_status", []string{
+		"repository",
+		"status",
+		"--json",
+	})
+	if err != nil {
+		return nil, err
+	}
+	repoStatus := repositoryStatus{}
+	err = json.Unmarshal(out, &repoStatus)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse repository status: %w", err)
+	}
+
+	info, err := k.GetMaintenanceInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		FormatVersion:        repoStatus.ContentFormat.Version,
+		Encryption:           repoStatus.ContentFormat.Encryption,
+		Hash:                 repoStatus.ContentFormat.Hash,
+		MaintenanceOwner:     info.Params.Owner,
+		LastQuickMaintenance: info.Schedule.LastQuickMaintenance(),
+		LastFullMaintenance:  info.Schedule.LastFullMaintenance(),
+		NextQuickMaintenance: info.Schedule.NextQuickMaintenance,
+		NextFullMaintenance:  info.Schedule.NextFullMaintenance,
+	}
+	return status, nil
+}
+
+// AddBlobStats sets the number and the total size of the blobs in the status.
+// Neither the status nor the maintenance info contain them, so all blobs have to be listed,
+// which takes long and is expensive for large repositories in object storages.
+func (k *Kopia) AddBlobStats(status *Status) error {
+	out, err := k.runKopiaCommandWithOutput("blob_list", []string{
+		"blob",
+		"list",
+		"--json",
+	})
+	if err != nil {
+		return err
+	}
+	blobs := []blob{}
+	err = json.Unmarshal(out, &blobs)
+	if err != nil {
+		return fmt.Errorf("cannot parse blob list: %w", err)
+	}
+
+	count := len(blobs)
+	size := int64(0)
+	for _, b := range blobs {
+		size += b.Length
+	}
+	status.BlobCount = &count
+	status.TotalSize = &size
+	return nil
+}
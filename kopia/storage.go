@@ -0,0 +1,551 @@
+package kopia
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// StorageS3 stores the repository in an S3 compatible bucket.
+	StorageS3 = "s3"
+	// StorageFilesystem stores the repository in a local directory, e.g. a mounted NFS share.
+	StorageFilesystem = "filesystem"
+	// StorageSFTP stores the repository on an SFTP server.
+	StorageSFTP = "sftp"
+	// StorageAzure stores the repository in an Azure blob storage container.
+	StorageAzure = "azure"
+	// StorageGCS stores the repository in a Google Cloud Storage bucket.
+	StorageGCS = "gcs"
+	// StorageB2 stores the repository in a Backblaze B2 bucket.
+	StorageB2 = "b2"
+)
+
+// fileWriter is implemented by storages that need credential files on disk.
+type fileWriter interface {
+	// writeFiles writes the files to the given directory and returns the storage that refers to them.
+	writeFiles(dir string) (Storage, error)
+}
+
+// configFileStorage is implemented by storages that kopia has to read from a storage config file instead of the flags.
+type configFileStorage interface {
+	// storageConfigFile returns the path of the file or "" if the flags can be used.
+	storageConfigFile() string
+}
+
+// Storage is a backend kopia stores the repository in.
+type Storage interface {
+	// Type is the name of the backend in kopia, e.g. "s3".
+	Type() string
+	// Args returns the flags for `kopia repository create <type>` and `kopia repository connect <type>`.
+	Args() []string
+	// Env returns the environment for `kopia repository create <type>`, which contains the credentials.
+	Env() []string
+	// Config returns the storage config that gets written to the kopia config file.
+	Config() interface{}
+	// Secrets returns the values that must never show up in the logs.
+	Secrets() []string
+	// Validate returns an error if a required setting is missing.
+	Validate() error
+}
+
+// S3Options configure the S3 bucket the repository is stored in.
+type S3Options struct {
+	Bucket string
+	// Prefix is prepended to all objects in the bucket, so that multiple repositories can share a bucket.
+	Prefix          string
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	// DisableTLS uses plain HTTP to connect to the endpoint.
+	DisableTLS bool
+	// DisableTLSVerification skips the verification of the endpoint's certificate.
+	DisableTLSVerification bool
+}
+
+type s3Config struct {
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix,omitempty"`
+	Endpoint        string `json:"endpoint"`
+	DoNotUseTLS     bool   `json:"doNotUseTLS,omitempty"`
+	DoNotVerifyTLS  bool   `json:"doNotVerifyTLS,omitempty"`
+	AccessKeyID     string `json:"accessKeyID"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	SessionToken    string `json:"sessionToken"`
+}
+
+// Type returns "s3".
+func (s S3Options) Type() string {
+	return StorageS3
+}
+
+// Args returns the bucket, endpoint and TLS flags.
+func (s S3Options) Args() []string {
+	args := []string{
+		"--bucket",
+		s.Bucket,
+		"--endpoint",
+		s.Endpoint,
+	}
+	if s.Prefix != "" {
+		args = append(args, "--prefix", s.Prefix)
+	}
+	if s.DisableTLS {
+		args = append(args, "--disable-tls")
+	}
+	if s.DisableTLSVerification {
+		args = append(args, "--disable-tls-verification")
+	}
+	return args
+}
+
+// Env returns the access keys, kopia reads them from these variables if the flags aren't set.
+func (s S3Options) Env() []string {
+	return []string{
+		"AWS_ACCESS_KEY_ID=" + s.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + s.SecretAccessKey,
+	}
+}
+
+// Config returns the S3 storage config.
+func (s S3Options) Config() interface{} {
+	return s3Config{
+		Bucket:          s.Bucket,
+		Prefix:          s.Prefix,
+		Endpoint:        s.Endpoint,
+		DoNotUseTLS:     s.DisableTLS,
+		DoNotVerifyTLS:  s.DisableTLSVerification,
+		AccessKeyID:     s.AccessKeyID,
+		SecretAccessKey: s.SecretAccessKey,
+	}
+}
+
+// Secrets returns the access keys.
+func (s S3Options) Secrets() []string {
+	return []string{s.AccessKeyID, s.SecretAccessKey}
+}
+
+// Validate checks that the bucket and the endpoint are set.
+func (s S3Options) Validate() error {
+	if s.Bucket == "" {
+		return fmt.Errorf("s3 storage: bucket is required")
+	}
+	if s.Endpoint == "" {
+		return fmt.Errorf("s3 storage: endpoint is required")
+	}
+	return nil
+}
+
+// FilesystemOptions configure the directory the repository is stored in.
+type FilesystemOptions struct {
+	// Path is the directory of the repository.
+	Path string
+}
+
+type filesystemConfig struct {
+	Path string `json:"path"`
+}
+
+// Type returns "filesystem".
+func (f FilesystemOptions) Type() string {
+	return StorageFilesystem
+}
+
+// Args returns the path flag.
+func (f FilesystemOptions) Args() []string {
+	return []string{
+		"--path",
+		f.Path,
+	}
+}
+
+// Env returns nothing, as there are no credentials.
+func (f FilesystemOptions) Env() []string {
+	return nil
+}
+
+// Config returns the filesystem storage config.
+func (f FilesystemOptions) Config() interface{} {
+	return filesystemConfig{
+		Path: f.Path,
+	}
+}
+
+// Secrets returns nothing, as there are no credentials.
+func (f FilesystemOptions) Secrets() []string {
+	return nil
+}
+
+// Validate checks that the path is set.
+func (f FilesystemOptions) Validate() error {
+	if f.Path == "" {
+		return fmt.Errorf("filesystem storage: path is required")
+	}
+	return nil
+}
+
+// SFTPOptions configure the SFTP server the repository is stored in.
+type SFTPOptions struct {
+	Host string
+	Port int
+	// Path is the directory of the repository on the server.
+	Path     string
+	Username string
+	// Password is only used if there's no key.
+	// It's passed to kopia in a storage config file, as kopia only accepts it as flag otherwise.
+	Password string
+	KeyFile  string
+	// KeyData is the private key. It gets written to a file next to the kopia config if there's no KeyFile.
+	KeyData        string
+	KnownHostsFile string
+	// KnownHostsData are known_hosts entries. They get written to a file next to the kopia config if there's no KnownHostsFile.
+	KnownHostsData string
+	// configFile is the storage config file with the password, see writeFiles.
+	configFile string
+}
+
+type sftpConfig struct {
+	Path           string `json:"path"`
+	Host           string `json:"host"`
+	Port           int    `json:"port"`
+	Username       string `json:"username"`
+	Password       string `json:"password,omitempty"`
+	Keyfile        string `json:"keyfile,omitempty"`
+	KnownHostsFile string `json:"knownHostsFile,omitempty"`
+}
+
+// Type returns "sftp".
+func (s SFTPOptions) Type() string {
+	return StorageSFTP
+}
+
+// Args returns the server, the path, the known hosts and the key.
+// The password is never part of the args, as it would be visible in the process list. See storageConfigFile.
+func (s SFTPOptions) Args() []string {
+	args := []string{
+		"--host",
+		s.Host,
+		"--port",
+		strconv.Itoa(s.Port),
+		"--path",
+		s.Path,
+		"--username",
+		s.Username,
+		"--known-hosts",
+		s.KnownHostsFile,
+	}
+	if s.KeyFile != "" {
+		args = append(args, "--keyfile", s.KeyFile)
+	}
+	return args
+}
+
+// Env returns nothing, the key and the password are passed as files.
+func (s SFTPOptions) Env() []string {
+	return nil
+}
+
+// Config returns the SFTP storage config.
+func (s SFTPOptions) Config() interface{} {
+	config := sftpConfig{
+		Path:           s.Path,
+		Host:           s.Host,
+		Port:           s.Port,
+		Username:       s.Username,
+		Keyfile:        s.KeyFile,
+		KnownHostsFile: s.KnownHostsFile,
+	}
+	if s.KeyFile == "" {
+		config.Password = s.Password
+	}
+	return config
+}
+
+// Secrets returns the password and the key.
+func (s SFTPOptions) Secrets() []string {
+	return []string{s.Password, s.KeyData}
+}
+
+// Validate checks that the server, the path, the known hosts and either a key or a password are set.
+func (s SFTPOptions) Validate() error {
+	if s.Host == "" || s.Path == "" || s.Username == "" {
+		return fmt.Errorf("sftp storage: host, path and username are required")
+	}
+	if s.KnownHostsFile == "" && s.KnownHostsData == "" {
+		return fmt.Errorf("sftp storage: known hosts are required")
+	}
+	if s.KeyFile == "" && s.KeyData == "" && s.Password == "" {
+		return fmt.Errorf("sftp storage: either a key or a password is required")
+	}
+	return nil
+}
+
+// storageConfigFile returns the storage config file kopia has to read the password from, if there's no key.
+func (s SFTPOptions) storageConfigFile() string {
+	return s.configFile
+}
+
+// writeFiles writes the key and the known hosts to the given directory, if they aren't files already.
+// Without a key, the storage config including the password is written as well.
+func (s SFTPOptions) writeFiles(dir string) (Storage, error) {
+	if s.KeyFile == "" && s.KeyData != "" {
+		s.KeyFile = filepath.Join(dir, "sftp-key")
+		err := os.WriteFile(s.KeyFile, []byte(s.KeyData), os.FileMode(0600))
+		if err != nil {
+			return s, err
+		}
+	}
+	if s.KnownHostsFile == "" && s.KnownHostsData != "" {
+		s.KnownHostsFile = filepath.Join(dir, "known_hosts")
+		err := os.WriteFile(s.KnownHostsFile, []byte(s.KnownHostsData), os.FileMode(0600))
+		if err != nil {
+			return s, err
+		}
+	}
+	if s.KeyFile == "" && s.Password != "" {
+		config, err := json.Marshal(struct {
+			Storage storage `json:"storage"`
+		}{storage{Type: s.Type(), Config: s.Config()}})
+		if err != nil {
+			return s, err
+		}
+		s.configFile = filepath.Join(dir, "sftp-storage.json")
+		err = os.WriteFile(s.configFile, config, os.FileMode(0600))
+		if err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// AzureOptions configure the Azure blob storage container the repository is stored in.
+type AzureOptions struct {
+	Container string
+	// Prefix is prepended to all blobs in the container.
+	Prefix         string
+	StorageAccount string
+	// StorageKey is the access key of the storage account.
+	StorageKey string
+	// SASToken is used instead of the StorageKey if set.
+	SASToken string
+	// StorageDomain is only needed for other clouds than the Azure public cloud.
+	StorageDomain string
+}
+
+type azureConfig struct {
+	Container      string `json:"container"`
+	Prefix         string `json:"prefix,omitempty"`
+	StorageAccount string `json:"storageAccount"`
+	StorageKey     string `json:"storageKey,omitempty"`
+	SASToken       string `json:"sasToken,omitempty"`
+	StorageDomain  string `json:"storageDomain,omitempty"`
+}
+
+// Type returns "azure".
+func (a AzureOptions) Type() string {
+	return StorageAzure
+}
+
+// Args returns the container flags.
+func (a AzureOptions) Args() []string {
+	args := []string{
+		"--container",
+		a.Container,
+	}
+	if a.Prefix != "" {
+		args = append(args, "--prefix", a.Prefix)
+	}
+	if a.StorageDomain != "" {
+		args = append(args, "--storage-domain", a.StorageDomain)
+	}
+	return args
+}
+
+// Env returns the storage account and its key or SAS token, kopia reads them from these variables if the flags aren't set.
+func (a AzureOptions) Env() []string {
+	env := []string{"AZURE_STORAGE_ACCOUNT=" + a.StorageAccount}
+	if a.SASToken != "" {
+		return append(env, "AZURE_STORAGE_SAS_TOKEN="+a.SASToken)
+	}
+	return append(env, "AZURE_STORAGE_KEY="+a.StorageKey)
+}
+
+// Config returns the Azure storage config.
+func (a AzureOptions) Config() interface{} {
+	config := azureConfig{
+		Container:      a.Container,
+		Prefix:         a.Prefix,
+		StorageAccount: a.StorageAccount,
+		StorageDomain:  a.StorageDomain,
+	}
+	if a.SASToken != "" {
+		config.SASToken = a.SASToken
+	} else {
+		config.StorageKey = a.StorageKey
+	}
+	return config
+}
+
+// Secrets returns the storage key and the SAS token.
+func (a AzureOptions) Secrets() []string {
+	return []string{a.StorageKey, a.SASToken}
+}
+
+// Validate checks that the container, the storage account and either a key or a SAS token are set.
+func (a AzureOptions) Validate() error {
+	if a.Container == "" || a.StorageAccount == "" {
+		return fmt.Errorf("azure storage: container and storage account are required")
+	}
+	if a.StorageKey == "" && a.SASToken == "" {
+		return fmt.Errorf("azure storage: either a storage key or a SAS token is required")
+	}
+	return nil
+}
+
+// GCSOptions configure the Google Cloud Storage bucket the repository is stored in.
+type GCSOptions struct {
+	Bucket string
+	// Prefix is prepended to all objects in the bucket.
+	Prefix string
+	// CredentialsFile is the JSON key of a service account.
+	CredentialsFile string
+	// CredentialsData is the JSON key of a service account.
+	// It gets written to a file next to the kopia config if there's no CredentialsFile.
+	CredentialsData string
+}
+
+type gcsConfig struct {
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix,omitempty"`
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+}
+
+// Type returns "gcs".
+func (g GCSOptions) Type() string {
+	return StorageGCS
+}
+
+// Args returns the bucket and the credentials file flags.
+func (g GCSOptions) Args() []string {
+	args := []string{
+		"--bucket",
+		g.Bucket,
+		"--credentials-file",
+		g.CredentialsFile,
+	}
+	if g.Prefix != "" {
+		args = append(args, "--prefix", g.Prefix)
+	}
+	return args
+}
+
+// Env returns nothing, the credentials are passed as file.
+func (g GCSOptions) Env() []string {
+	return nil
+}
+
+// Config returns the GCS storage config.
+func (g GCSOptions) Config() interface{} {
+	return gcsConfig{
+		Bucket:          g.Bucket,
+		Prefix:          g.Prefix,
+		CredentialsFile: g.CredentialsFile,
+	}
+}
+
+// Secrets returns the service account key.
+func (g GCSOptions) Secrets() []string {
+	return []string{g.CredentialsData}
+}
+
+// Validate checks that the bucket and the credentials are set.
+func (g GCSOptions) Validate() error {
+	if g.Bucket == "" {
+		return fmt.Errorf("gcs storage: bucket is required")
+	}
+	if g.CredentialsFile == "" && g.CredentialsData == "" {
+		return fmt.Errorf("gcs storage: credentials are required")
+	}
+	return nil
+}
+
+// writeFiles writes the credentials to the given directory, if they aren't a file already.
+func (g GCSOptions) writeFiles(dir string) (Storage, error) {
+	if g.CredentialsFile == "" && g.CredentialsData != "" {
+		g.CredentialsFile = filepath.Join(dir, "gcs-credentials.json")
+		err := os.WriteFile(g.CredentialsFile, []byte(g.CredentialsData), os.FileMode(0600))
+		if err != nil {
+			return g, err
+		}
+	}
+	return g, nil
+}
+
+// B2Options configure the Backblaze B2 bucket the repository is stored in.
+type B2Options struct {
+	Bucket string
+	// Prefix is prepended to all objects in the bucket.
+	Prefix string
+	KeyID  string
+	Key    string
+}
+
+type b2Config struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix,omitempty"`
+	KeyID  string `json:"keyID"`
+	Key    string `json:"key"`
+}
+
+// Type returns "b2".
+func (b B2Options) Type() string {
+	return StorageB2
+}
+
+// Args returns the bucket flags.
+func (b B2Options) Args() []string {
+	args := []string{
+		"--bucket",
+		b.Bucket,
+	}
+	if b.Prefix != "" {
+		args = append(args, "--prefix", b.Prefix)
+	}
+	return args
+}
+
+// Env returns the application key, kopia reads it from these variables if the flags aren't set.
+func (b B2Options) Env() []string {
+	return []string{
+		"B2_KEY_ID=" + b.KeyID,
+		"B2_KEY=" + b.Key,
+	}
+}
+
+// Config returns the B2 storage config.
+func (b B2Options) Config() interface{} {
+	return b2Config{
+		Bucket: b.Bucket,
+		Prefix: b.Prefix,
+		KeyID:  b.KeyID,
+		Key:    b.Key,
+	}
+}
+
+// Secrets returns the application key.
+func (b B2Options) Secrets() []string {
+	return []string{b.KeyID, b.Key}
+}
+
+// Validate checks that the bucket and the application key are set.
+func (b B2Options) Validate() error {
+	if b.Bucket == "" {
+		return fmt.Errorf("b2 storage: bucket is required")
+	}
+	if b.KeyID == "" || b.Key == "" {
+		return fmt.Errorf("b2 storage: key ID and key are required")
+	}
+	return nil
+}
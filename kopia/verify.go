@@ -0,0 +1,148 @@
+package kopia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VerifyOptions define how thoroughly the snapshots get verified.
+type VerifyOptions struct {
+	// FilesPercent is the share of files whose contents get read, from 0 to 100.
+	// With 0 only the metadata of the snapshots is verified.
+	FilesPercent float64
+	// MaxDuration stops the verification after this time if it's not zero.
+	// What was verified until then is still reported.
+	MaxDuration time.Duration
+}
+
+// VerifyReport contains the outcome of a verification.
+type VerifyReport struct {
+	ObjectsVerified int     `json:"objectsVerified"`
+	FilesPercent    float64 `json:"filesPercent"`
+	// ErrorCount is kept even if the errors are dropped, e.g. to fit into a termination message.
+	ErrorCount int          `json:"errorCount"`
+	Errors     []EntryError `json:"errors,omitempty"`
+	// AffectedSnapshots contains the snapshots with errors as <source>@<start time>.
+	AffectedSnapshots []string      `json:"affectedSnapshots,omitempty"`
+	Duration          time.Duration `json:"duration"`
+	// Incomplete is set if the verification was stopped because it reached MaxDuration.
+	Incomplete bool `json:"incomplete"`
+}
+
+// Failed returns true if the verification found any errors.
+func (r *VerifyReport) Failed() bool {
+	return r.ErrorCount > 0
+}
+
+// Verify checks that the snapshots in the repository can be read.
+// The report is also returned together with an error, if kopia found errors.
+func (k *Kopia) Verify(opts VerifyOptions) (*VerifyReport, error) {
+	log := k.log.WithName("verify")
+
+	if opts.FilesPercent < 0 || opts.FilesPercent > 100 {
+		return nil, fmt.Errorf("files percent has to be between 0 and 100, got %v", opts.FilesPercent)
+	}
+
+	// The snapshots are needed to find the ones affected by errors.
+	snapshots, err := k.ListSnapshots("", "")
+	if err != nil {
+		return nil, err
+	}
+
+	log.V(1).Info("verifying snapshots", "filesPercent", opts.FilesPercent, "maxDuration", opts.MaxDuration.String())
+	kc := k.newKopiaCommand("verify", []string{
+		"snapshot",
+		"verify",
+		"--verify-files-percent",
+		strconv.FormatFloat(opts.FilesPercent, 'f', -1, 64),
+	})
+	if opts.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		kc.ctx, cancel = context.WithTimeout(kc.ctx, opts.MaxDuration)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err = k.execute("verify", &kc)
+	report := &VerifyReport{
+		ObjectsVerified:   kc.parser.verifyStats.objects,
+		FilesPercent:      opts.FilesPercent,
+		ErrorCount:        len(kc.parser.verifyStats.errors),
+		Errors:            kc.parser.verifyStats.errors,
+		AffectedSnapshots: affectedSnapshots(kc.parser.verifyStats.errors, snapshots),
+		Duration:          time.Since(start),
+		Incomplete:        errors.Is(kc.ctx.Err(), context.DeadlineExceeded),
+	}
+
+	if report.Incomplete {
+		log.Info("verification reached the maximum duration", "maxDuration", opts.MaxDuration.String())
+		err = nil
+	}
+	if err == nil && report.Failed() {
+		err = fmt.Errorf("kopia found %d errors", report.ErrorCount)
+	}
+	return report, err
+}
+
+// affectedSnapshots returns the snapshots the errors belong to.
+// Kopia prefixes the paths with <source>@<start time> of the snapshot.
+func affectedSnapshots(entryErrors []EntryError, snapshots []Snapshot) []string {
+	affected := map[string]bool{}
+	for _, entryErr := range entryErrors {
+		for _, snapshot := range snapshots {
+			prefix := snapshot.Source.String() + "@"
+			if !strings.HasPrefix(entryErr.Path, prefix) {
+				continue
+			}
+			root := entryErr.Path
+			if i := strings.Index(root[len(prefix):], "/"); i >= 0 {
+				root = root[:len(prefix)+i]
+			}
+			affected[root] = true
+			break
+		}
+	}
+
+	roots := []string{}
+	for root := range affected {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+type verifyStats struct {
+	objects int
+	errors  []EntryError
+}
+
+var (
+	// verifyProgressRegex matches the progress kopia prints while verifying, for example:
+	// "Processed 1234 objects." or "Finished processing 1234 objects."
+	verifyProgressRegex = regexp.MustCompile(`(?:Processed|Finished processing) (\d+) objects`)
+	// verifyErrorRegex matches the errors kopia prints while verifying, for example:
+	// "error processing kopia-k8s@ns:/data/pvc@2022-03-01 10:00:00 UTC/file: object not found"
+	verifyErrorRegex = regexp.MustCompile(`error processing (.+?): (.+)$`)
+)
+
+// add updates the stats with the line and returns false if it's not about the verification.
+func (s *verifyStats) add(line string) bool {
+	if match := verifyProgressRegex.FindStringSubmatch(line); match != nil {
+		objects, _ := strconv.Atoi(match[1])
+		if objects > s.objects {
+			s.objects = objects
+		}
+		return true
+	}
+	if match := verifyErrorRegex.FindStringSubmatch(line); match != nil {
+		s.errors = append(s.errors, EntryError{Path: match[1], Error: match[2]})
+		return true
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+)
+
+// Mask replaces registered secrets in the logs.
+const Mask = "*****"
+
+var registry = &secretRegistry{}
+
+type secretRegistry struct {
+	mutex    sync.RWMutex
+	secrets  []string
+	replacer *strings.Replacer
+}
+
+// RegisterSecret registers values that get masked in every log line of a logger returned by NewRedactingLogger.
+// Empty values are ignored.
+func RegisterSecret(values ...string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	changed := false
+	for _, value := range values {
+		if value == "" || registry.contains(value) {
+			continue
+		}
+		registry.secrets = append(registry.secrets, value)
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	// Longer secrets have to be replaced first, in case one secret contains another.
+	sort.Slice(registry.secrets, func(i, j int) bool {
+		return len(registry.secrets[i]) > len(registry.secrets[j])
+	})
+	pairs := make([]string, 0, len(registry.secrets)*2)
+	for _, secret := range registry.secrets {
+		pairs = append(pairs, secret, Mask)
+	}
+	registry.replacer = strings.NewReplacer(pairs...)
+}
+
+func (r *secretRegistry) contains(value string) bool {
+	for _, secret := range r.secrets {
+		if secret == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact masks all registered secrets in s.
+func Redact(s string) string {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	if registry.replacer == nil {
+		return s
+	}
+	return registry.replacer.Replace(s)
+}
+
+// NewRedactingLogger returns a logger that masks all registered secrets in the messages, names and values it logs.
+// Names and values are redacted when a line gets logged, so secrets registered after WithName or WithValues are masked as well.
+func NewRedactingLogger(l logr.Logger) logr.Logger {
+	return logr.New(&redactingSink{sink: l.GetSink()})
+}
+
+type redactingSink struct {
+	sink logr.LogSink
+	// names and values are only passed to the sink when a line gets logged.
+	names  []string
+	values []interface{}
+}
+
+// Init doesn't initialize the wrapped sink again, as it was initialized when its logger got created.
+// It only needs to skip the frame of the redactingSink.
+func (s *redactingSink) Init(info logr.RuntimeInfo) {
+	if sink, ok := s.sink.(logr.CallDepthLogSink); ok {
+		s.sink = sink.WithCallDepth(1)
+	}
+}
+
+func (s *redactingSink) Enabled(level int) bool {
+	return s.sink.Enabled(level)
+}
+
+func (s *redactingSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.redactedSink().Info(level, Redact(msg), redactValues(keysAndValues)...)
+}
+
+func (s *redactingSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.redactedSink().Error(redactError(err), Redact(msg), redactValues(keysAndValues)...)
+}
+
+// redactedSink returns the wrapped sink with the redacted names and values.
+func (s *redactingSink) redactedSink() logr.LogSink {
+	sink := s.sink
+	for _, name := range s.names {
+		sink = sink.WithName(Redact(name))
+	}
+	if len(s.values) > 0 {
+		sink = sink.WithValues(redactValues(s.values)...)
+	}
+	return sink
+}
+
+func (s *redactingSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	values := append(append([]interface{}{}, s.values...), keysAndValues...)
+	return &redactingSink{sink: s.sink, names: s.names, values: values}
+}
+
+func (s *redactingSink) WithName(name string) logr.LogSink {
+	names := append(append([]string{}, s.names...), name)
+	return &redactingSink{sink: s.sink, names: names, values: s.values}
+}
+
+func (s *redactingSink) WithCallDepth(depth int) logr.LogSink {
+	if sink, ok := s.sink.(logr.CallDepthLogSink); ok {
+		return &redactingSink{sink: sink.WithCallDepth(depth), names: s.names, values: s.values}
+	}
+	return s
+}
+
+func redactValues(keysAndValues []interface{}) []interface{} {
+	redacted := make([]interface{}, len(keysAndValues))
+	for i, value := range keysAndValues {
+		redacted[i] = redactValue(value)
+	}
+	return redacted
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return Redact(v)
+	case error:
+		return redactError(v)
+	case fmt.Stringer:
+		return Redact(v.String())
+	case []string:
+		redacted := make([]string, len(v))
+		for i := range v {
+			redacted[i] = Redact(v[i])
+		}
+		return redacted
+	case bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return v
+	default:
+		// Other values like structs are only replaced if they contain a secret.
+		formatted := fmt.Sprintf("%+v", v)
+		if redacted := Redact(formatted); redacted != formatted {
+			return redacted
+		}
+		return v
+	}
+}
+
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if redacted := Redact(msg); redacted != msg {
+		return errors.New(redacted)
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+)
+
+type stringer struct{ value string }
+
+func (s stringer) String() string { return "stringer " + s.value }
+
+type credentials struct {
+	User     string
+	Password string
+}
+
+// newTestLogger returns a redacting logger and the lines it logged.
+func newTestLogger() (logr.Logger, *[]string) {
+	lines := &[]string{}
+	l := funcr.New(func(prefix, args string) {
+		*lines = append(*lines, prefix+" "+args)
+	}, funcr.Options{})
+	return NewRedactingLogger(l), lines
+}
+
+func TestRedact(t *testing.T) {
+	RegisterSecret("redact-short", "redact-short-and-long", "")
+
+	tests := map[string]struct {
+		input string
+		want  string
+	}{
+		"no secret":          {input: "nothing to hide", want: "nothing to hide"},
+		"secret":             {input: "password redact-short!", want: "password " + Mask + "!"},
+		"overlapping":        {input: "key=redact-short-and-long;", want: "key=" + Mask + ";"},
+		"several":            {input: "redact-short redact-short-and-long", want: Mask + " " + Mask},
+		"empty isn't masked": {input: "", want: ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := Redact(tt.input); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactingLogger(t *testing.T) {
+	const secret = "logger-secret"
+	RegisterSecret(secret)
+
+	tests := map[string]func(l logr.Logger){
+		"message": func(l logr.Logger) {
+			l.Info("connecting with " + secret)
+		},
+		"string value": func(l logr.Logger) {
+			l.Info("connecting", "password", secret)
+		},
+		"string slice value": func(l logr.Logger) {
+			l.Info("running", "args", []string{"--password", secret})
+		},
+		"error": func(l logr.Logger) {
+			l.Error(fmt.Errorf("wrong password %s", secret), "connect failed")
+		},
+		"error value": func(l logr.Logger) {
+			l.Info("connect failed", "reason", errors.New("wrong password "+secret))
+		},
+		"stringer value": func(l logr.Logger) {
+			l.Info("connecting", "config", stringer{secret})
+		},
+		"struct value": func(l logr.Logger) {
+			l.Info("connecting", "credentials", credentials{User: "kopia", Password: secret})
+		},
+		"struct pointer value": func(l logr.Logger) {
+			l.Info("connecting", "credentials", &credentials{User: "kopia", Password: secret})
+		},
+		"map value": func(l logr.Logger) {
+			l.Info("connecting", "env", map[string]string{"KOPIA_PASSWORD": secret})
+		},
+		"with values": func(l logr.Logger) {
+			l.WithValues("password", secret).Info("connecting")
+		},
+		"with name": func(l logr.Logger) {
+			l.WithName(secret).Info("connecting")
+		},
+	}
+	for name, log := range tests {
+		t.Run(name, func(t *testing.T) {
+			l, lines := newTestLogger()
+			log(l)
+			if len(*lines) != 1 {
+				t.Fatalf("got %d lines, want 1", len(*lines))
+			}
+			if strings.Contains((*lines)[0], secret) || !strings.Contains((*lines)[0], Mask) {
+				t.Errorf("secret wasn't masked: %s", (*lines)[0])
+			}
+		})
+	}
+}
+
+func TestRedactingLoggerLateSecret(t *testing.T) {
+	const secret = "late-secret"
+	l, lines := newTestLogger()
+	l = l.WithName("repo-"+secret).WithValues("password", secret).WithName("connect")
+
+	RegisterSecret(secret)
+	l.Info("connecting", "other", "value")
+
+	if len(*lines) != 1 {
+		t.Fatalf("got %d lines, want 1", len(*lines))
+	}
+	line := (*lines)[0]
+	if strings.Contains(line, secret) {
+		t.Errorf("secret registered after WithName and WithValues wasn't masked: %s", line)
+	}
+	for _, want := range []string{"repo-" + Mask + "/connect", `"password"="` + Mask + `"`, `"other"="value"`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("line %s doesn't contain %s", line, want)
+		}
+	}
+}
+
+func TestRedactingLoggerKeepsValues(t *testing.T) {
+	l, lines := newTestLogger()
+	l.Info("backup finished", "files", 3, "ok", true, "duration", "1s")
+	if want := `"files"=3 "ok"=true "duration"="1s"`; !strings.Contains((*lines)[0], want) {
+		t.Errorf("line %s doesn't contain %s", (*lines)[0], want)
+	}
+}
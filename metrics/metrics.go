@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"time"
+
+	"git.earthnet.ch/simon.beck/kopia-k8s/kopia"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "kopia_k8s"
+
+var labels = []string{"namespace", "pvc"}
+
+var (
+	backupLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backup_last_success_timestamp",
+		Help:      "Unix timestamp of the last successful backup of the PVC",
+	}, labels)
+
+	backupDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backup_duration_seconds",
+		Help:      "Duration of the last backup of the PVC",
+	}, labels)
+
+	backupBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backup_bytes",
+		Help:      "Size of the last snapshot of the PVC",
+	}, labels)
+
+	backupFiles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backup_files",
+		Help:      "Number of files in the last snapshot of the PVC",
+	}, labels)
+
+	backupFailedFiles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backup_failed_files",
+		Help:      "Number of files that could not be backed up in the last backup of the PVC",
+	}, labels)
+
+	jobsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_failed_total",
+		Help:      "Number of failed jobs per PVC",
+	}, labels)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		backupLastSuccess,
+		backupDuration,
+		backupBytes,
+		backupFiles,
+		backupFailedFiles,
+		jobsFailed,
+	)
+}
+
+// RecordBackupResult updates the backup metrics of the PVC with the result of a successful backup job.
+func RecordBackupResult(pvcNamespace, pvc string, finished time.Time, result *kopia.BackupResult) {
+	backupLastSuccess.WithLabelValues(pvcNamespace, pvc).Set(float64(finished.Unix()))
+	backupDuration.WithLabelValues(pvcNamespace, pvc).Set(result.Duration.Seconds())
+	backupBytes.WithLabelValues(pvcNamespace, pvc).Set(float64(result.Size))
+	backupFiles.WithLabelValues(pvcNamespace, pvc).Set(float64(result.Files))
+	backupFailedFiles.WithLabelValues(pvcNamespace, pvc).Set(float64(result.FailedFiles))
+}
+
+// RecordFailedJob counts a failed job of the PVC.
+func RecordFailedJob(pvcNamespace, pvc string) {
+	jobsFailed.WithLabelValues(pvcNamespace, pvc).Inc()
+}
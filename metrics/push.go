@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// RunStats contains the aggregated outcome of a single `operator backup` run.
+type RunStats struct {
+	PVCsDiscovered         int
+	JobsSucceeded          int
+	JobsFailed             int
+	JobsSkippedPending     int
+	PrebackupHookFailures  int
+	PostbackupHookFailures int
+	Duration               time.Duration
+}
+
+// Pusher pushes the RunStats of a run to a Prometheus pushgateway.
+type Pusher struct {
+	URL      string
+	Job      string
+	Grouping map[string]string
+}
+
+// Push replaces all metrics of the job and grouping on the pushgateway with the given stats.
+func (p Pusher) Push(stats RunStats) error {
+	registry := prometheus.NewRegistry()
+
+	gauge := func(name, help string, value float64) {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      help,
+		})
+		g.Set(value)
+		registry.MustRegister(g)
+	}
+
+	gauge("run_pvcs_discovered", "Number of PVCs discovered in the last run", float64(stats.PVCsDiscovered))
+	gauge("run_jobs_succeeded", "Number of backup jobs that succeeded in the last run", float64(stats.JobsSucceeded))
+	gauge("run_jobs_failed", "Number of backup jobs that failed in the last run", float64(stats.JobsFailed))
+	gauge("run_jobs_skipped_pending", "Number of backup jobs that were skipped in the last run, because their pod was pending", float64(stats.JobsSkippedPending))
+	gauge("run_prebackup_hook_failures", "Number of pre-backup commands that failed in the last run", float64(stats.PrebackupHookFailures))
+	gauge("run_postbackup_hook_failures", "Number of post-backup commands that failed in the last run", float64(stats.PostbackupHookFailures))
+	gauge("run_duration_seconds", "Duration of the last run", stats.Duration.Seconds())
+	gauge("run_last_timestamp", "Unix timestamp of the end of the last run", float64(time.Now().Unix()))
+
+	pusher := push.New(p.URL, p.Job).Gatherer(registry)
+	for name, value := range p.Grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+	return pusher.Push()
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestPusherPush(t *testing.T) {
+	var (
+		method   string
+		path     string
+		families = map[string]*dto.MetricFamily{}
+	)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		path = r.URL.Path
+		decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			family := &dto.MetricFamily{}
+			err := decoder.Decode(family)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Errorf("cannot decode pushed metrics: %v", err)
+				break
+			}
+			families[family.GetName()] = family
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	pusher := Pusher{
+		URL:      gateway.URL,
+		Job:      "kopia-k8s",
+		Grouping: map[string]string{"cluster": "home"},
+	}
+	err := pusher.Push(RunStats{
+		PVCsDiscovered:         4,
+		JobsSucceeded:          2,
+		JobsFailed:             1,
+		JobsSkippedPending:     1,
+		PrebackupHookFailures:  3,
+		PostbackupHookFailures: 5,
+		Duration:               90 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+
+	if method != http.MethodPut {
+		t.Errorf("got method %s, want %s so the previous run's metrics get replaced", method, http.MethodPut)
+	}
+	if want := "/metrics/job/kopia-k8s/cluster/home"; path != want {
+		t.Errorf("got path %q, want %q", path, want)
+	}
+
+	want := map[string]float64{
+		"kopia_k8s_run_pvcs_discovered":          4,
+		"kopia_k8s_run_jobs_succeeded":           2,
+		"kopia_k8s_run_jobs_failed":              1,
+		"kopia_k8s_run_jobs_skipped_pending":     1,
+		"kopia_k8s_run_prebackup_hook_failures":  3,
+		"kopia_k8s_run_postbackup_hook_failures": 5,
+		"kopia_k8s_run_duration_seconds":         90,
+	}
+	for name, value := range want {
+		family, ok := families[name]
+		if !ok {
+			t.Errorf("metric %s wasn't pushed", name)
+			continue
+		}
+		if got := family.GetMetric()[0].GetGauge().GetValue(); got != value {
+			t.Errorf("metric %s is %v, want %v", name, got, value)
+		}
+	}
+	if _, ok := families["kopia_k8s_run_last_timestamp"]; !ok {
+		t.Errorf("metric kopia_k8s_run_last_timestamp wasn't pushed")
+	}
+	if len(families) != len(want)+1 {
+		t.Errorf("got %d metric families, want %d", len(families), len(want)+1)
+	}
+}
+
+func TestPusherPushError(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer gateway.Close()
+
+	err := Pusher{URL: gateway.URL, Job: "kopia-k8s"}.Push(RunStats{})
+	if err == nil {
+		t.Fatal("expected an error if the pushgateway rejects the metrics")
+	}
+}
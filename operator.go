@@ -17,13 +17,18 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"os"
+	"path"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
+	kopiav1alpha1 "git.earthnet.ch/simon.beck/kopia-k8s/api/v1alpha1"
 	"git.earthnet.ch/simon.beck/kopia-k8s/controllers"
+	"git.earthnet.ch/simon.beck/kopia-k8s/k8s"
 	"git.earthnet.ch/simon.beck/kopia-k8s/logger"
+	"git.earthnet.ch/simon.beck/kopia-k8s/metrics"
 	"github.com/go-logr/logr"
 	"github.com/urfave/cli/v2"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
@@ -45,11 +50,14 @@ type operator struct {
 	log    logr.Logger
 	cliCtx *cli.Context
 	uuid   string
+	// watchResources enables the controllers for the kopia-k8s custom resources.
+	watchResources bool
 }
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
+	utilruntime.Must(kopiav1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -73,9 +81,18 @@ func (o *operator) runOperator() {
 
 func (o *operator) registerController(mgr manager.Manager) {
 
-	for name, reconciler := range map[string]controllers.ReconcilerSetup{
+	reconcilers := map[string]controllers.ReconcilerSetup{
 		"Job": &controllers.JobReconciler{},
-	} {
+	}
+	if o.watchResources {
+		reconcilers["Backup"] = &controllers.BackupReconciler{RunBackup: o.backupFunc(mgr)}
+		reconcilers["Schedule"] = &controllers.ScheduleReconciler{
+			RunMaintenance: o.maintenanceFunc(mgr),
+			RunCheck:       o.checkFunc(mgr),
+		}
+	}
+
+	for name, reconciler := range reconcilers {
 		if err := reconciler.SetupWithManager(mgr, o.log.WithName("controllers").WithName(name), o.uuid); err != nil {
 			o.log.Error(err, "unable to initialize operator mode", "step", "controller", "controller", name)
 			os.Exit(1)
@@ -94,6 +111,73 @@ func (o *operator) registerController(mgr manager.Manager) {
 	}
 }
 
+// backupFunc returns a function that runs the same backup as `operator backup`.
+func (o *operator) backupFunc(mgr manager.Manager) controllers.BackupFunc {
+	return func(ctx context.Context, opts k8s.BackupOptions) ([]k8s.FinishedJob, error) {
+		return runBackupFlow(o.cliCtx, mgr.GetClient(), opts, &metrics.RunStats{})
+	}
+}
+
+// maintenanceFunc returns a function that runs the same maintenance as `kopia maintenance`.
+func (o *operator) maintenanceFunc(mgr manager.Manager) controllers.RunFunc {
+	return func(ctx context.Context, repository *k8s.Repository) error {
+		c := o.withContext(ctx)
+		if repository == nil {
+			var err error
+			repository, err = resolveRepository(c, mgr.GetClient())
+			if err != nil {
+				return err
+			}
+		}
+		return maintainRepositoryWithLease(c, mgr.GetClient(), repository)
+	}
+}
+
+// checkFunc returns a function that verifies the snapshots in the repository.
+// Each run gets its own config, so concurrent runs don't overwrite each other's connection.
+func (o *operator) checkFunc(mgr manager.Manager) controllers.RunFunc {
+	return func(ctx context.Context, repository *k8s.Repository) error {
+		c := o.withContext(ctx)
+		if repository == nil {
+			var err error
+			repository, err = resolveRepository(c, mgr.GetClient())
+			if err != nil {
+				return err
+			}
+		}
+
+		checkPath := path.Join(c.Path("config"), "check")
+		err := os.MkdirAll(checkPath, os.FileMode(0700))
+		if err != nil {
+			return err
+		}
+		configPath, err := os.MkdirTemp(checkPath, repository.ID()+"-")
+		if err != nil {
+			return err
+		}
+		// The config contains the credentials of the storage.
+		defer os.RemoveAll(configPath)
+
+		k, err := newKopiaInstanceAs(c, repository, configPath, path.Join(c.Path("cache-path"), "check", repository.ID()), c.String("hostname"))
+		if err != nil {
+			return err
+		}
+		report, err := k.Verify(verifyOptionsFromFlags(c))
+		if report != nil {
+			logVerifyReport(c, report)
+		}
+		return err
+	}
+}
+
+// withContext returns a copy of the cli context that uses the given context, e.g. one that's canceled with the manager.
+// The logger of the cli context is kept.
+func (o *operator) withContext(ctx context.Context) *cli.Context {
+	c := *o.cliCtx
+	c.Context = context.WithValue(ctx, logger.ContextKey{}, o.cliCtx.Context.Value(logger.ContextKey{}))
+	return &c
+}
+
 func (o *operator) startManager(mgr manager.Manager) {
 	o.log.Info("starting manager")
 	go func() {
@@ -110,10 +194,10 @@ func (o *operator) initManager() manager.Manager {
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
-		MetricsBindAddress:     o.cliCtx.String("metricsAddr"),
+		MetricsBindAddress:     o.cliCtx.String("metrics-bind-address"),
 		Port:                   9443,
-		HealthProbeBindAddress: o.cliCtx.String("probeAddr"),
-		LeaderElection:         o.cliCtx.Bool("enableLeaderElection"),
+		HealthProbeBindAddress: o.cliCtx.String("health-probe-bind-address"),
+		LeaderElection:         o.cliCtx.Bool("leader-elect"),
 		LeaderElectionID:       "68072c41.earthnet.ch",
 	})
 	if err != nil {